@@ -1,9 +1,14 @@
 package all
 
 import (
+	_ "github.com/ncw/cwtool/cmd/decodetext"
+	_ "github.com/ncw/cwtool/cmd/devices"
+	_ "github.com/ncw/cwtool/cmd/encode"
+	_ "github.com/ncw/cwtool/cmd/formats"
 	_ "github.com/ncw/cwtool/cmd/gendocs"
 	_ "github.com/ncw/cwtool/cmd/keymorse"
 	_ "github.com/ncw/cwtool/cmd/ncwtester"
+	_ "github.com/ncw/cwtool/cmd/outputs"
 	_ "github.com/ncw/cwtool/cmd/play"
 	_ "github.com/ncw/cwtool/cmd/rss"
 )
@@ -3,6 +3,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -13,6 +16,12 @@ var (
 	Debug bool
 )
 
+// Functions to call on exit
+var (
+	atExitMu sync.Mutex
+	atExit   []func()
+)
+
 // Root represents the base command when called without any subcommands
 var Root = &cobra.Command{
 	Use:   "cwtool",
@@ -25,10 +34,41 @@ Cwtool provides a suite of Morse code tools.
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupt
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		Exit(code)
+	}()
 	err := Root.Execute()
 	if err != nil {
-		os.Exit(1)
+		Exit(1)
+	}
+}
+
+// AtExit registers fn to be called when cwtool is interrupted or
+// exits with Exit, eg to remove partially written files.
+func AtExit(fn func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExit = append(atExit, fn)
+}
+
+// Exit calls the functions registered with AtExit, most recent first,
+// then exits with code
+func Exit(code int) {
+	atExitMu.Lock()
+	fns := atExit
+	atExit = nil
+	atExitMu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
 	}
+	os.Exit(code)
 }
 
 func init() {
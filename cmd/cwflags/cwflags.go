@@ -2,10 +2,20 @@
 package cwflags
 
 import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/ncw/cwtool/cmd"
 	"github.com/ncw/cwtool/cw"
 	"github.com/ncw/cwtool/cwfile"
 	"github.com/ncw/cwtool/cwplayer"
+	"github.com/ncw/cwtool/cwserial"
+	"github.com/ncw/cwtool/cwvisual"
 	"github.com/spf13/pflag"
 )
 
@@ -20,9 +30,39 @@ var (
 	wpm        float64
 	farnsworth float64
 	frequency  float64
-	outputFile string
+	outputs    []string
+	device     string
+	backend    string
+	format     string
+	bitrate    int
+	unknown    cw.UnknownPolicy
+	cues       cw.Granularity
+	bext       bool
+	subtitles  string
+	subGran    = cw.GranularityItem
+	subDelay   time.Duration
+	split      string
+	playlist   string
+	force      bool
+	speaker    bool
+	level      levelValue
+	loudness   float64
+	bits       int
+	keyLine    string
+	pttLine    string
+	pttLead    time.Duration
+	pttTail    time.Duration
+	weighting  int
+	visual     bool
+	visStyle   string
+	visLetters bool
 )
 
+func init() {
+	// Don't leave partially written files behind if interrupted
+	cmd.AtExit(cwfile.RemoveTemporaryFiles)
+}
+
 // Add the CW flags to the flagset passed in
 func Add(flags *pflag.FlagSet) {
 	flags.IntVarP(&sampleRate, "samplerate", "s", 8000, "sample rate in samples/s")
@@ -30,28 +70,168 @@ func Add(flags *pflag.FlagSet) {
 	flags.Float64VarP(&wpm, "wpm", "", 25.0, "WPM to send at")
 	flags.Float64VarP(&farnsworth, "farnsworth", "", 0.0, "Increase character spacing to match this WPM")
 	flags.Float64VarP(&frequency, "frequency", "", 600.0, "HZ of Morse")
-	flags.StringVarP(&outputFile, "out", "", "", "WAV file for output instead of speaker")
+	flags.VarP(&level, "level", "", "Peak level of the Morse in dBFS below 0, eg -6, or -0.1 for nearly full scale - the default is about -10.5")
+	flags.Float64VarP(&loudness, "loudness", "", 0.0, "Normalise --out files to this integrated loudness in LUFS, eg -16 for podcasts")
+	flags.IntVarP(&bits, "bits", "", 16, "Bits per sample for --out files: 8|16|24|32 - mp3 is always 16")
+	flags.StringArrayVarP(&outputs, "out", "", nil, "Output instead of speaker, eg a file, - for stdout, speaker:, wav:FILE, tcp:HOST:PORT or serial:PORT?line=dtr - may be repeated, see cwtool outputs")
+	flags.StringVarP(&device, "device", "", "", "Audio output device to play to instead of the default - see cwtool devices")
+	flags.StringVarP(&backend, "backend", "", "", "Audio backend to play with: "+strings.Join(cwplayer.Backends(), "|")+" - capture:FILE records raw PCM to FILE (default $"+cwplayer.BackendEnv+" or "+cwplayer.DefaultBackend+")")
+	flags.BoolVarP(&speaker, "speaker", "", false, "If set play on the speaker as well as sending to --out")
+	flags.BoolVarP(&force, "force", "", false, "If set overwrite existing output files")
+	flags.StringVarP(&format, "format", "", "", "Format for --out if not set by its extension: "+strings.Join(cwfile.Formats(), "|")+" - see cwtool formats")
+	flags.IntVarP(&bitrate, "bitrate", "", cwfile.DefaultMP3Bitrate, "Bitrate in kbit/s for .mp3 output")
+	flags.VarP(&cues, "cues", "", "Mark the text in .wav output with labelled cue points: "+cw.GranularityNames("|"))
+	flags.BoolVarP(&bext, "bext", "", false, "If set write a Broadcast Wave bext chunk in .wav output")
+	flags.StringVarP(&subtitles, "subtitles", "", "", "Write subtitles for --out to this file - format is set by the extension: "+strings.Join(cwfile.SubtitleFormats(), "|"))
+	flags.VarP(&subGran, "subtitles-granularity", "", "How much text to put in each subtitle: "+cw.GranularityNames("|"))
+	flags.DurationVarP(&subDelay, "subtitles-delay", "", 0, "If set show each subtitle this long after its Morse has been heard rather than as it starts")
+	flags.StringVarP(&split, "split", "", "", "Split --out into numbered files, one per item or paragraph or after a duration, eg item, paragraph or 5m")
+	flags.StringVarP(&playlist, "playlist", "", "", "Playlist for --split files, .m3u or .pls (default --out with a .m3u extension)")
+	flags.StringVarP(&keyLine, "key-line", "", cwserial.DefaultKeyLine, "Serial port line to key the Morse with for --out serial:PORT: "+strings.Join(cwserial.Lines(), "|"))
+	flags.StringVarP(&pttLine, "ptt-line", "", "", "Serial port line to use for PTT with --out serial:PORT if set: "+strings.Join(cwserial.Lines(), "|"))
+	flags.DurationVarP(&pttLead, "ptt-lead", "", 50*time.Millisecond, "Time to turn PTT on before the Morse starts")
+	flags.DurationVarP(&pttTail, "ptt-tail", "", 200*time.Millisecond, "Time to keep PTT on after the Morse ends")
+	flags.IntVarP(&weighting, "weighting", "", cwserial.DefaultWeighting, "Weighting in percent for --out winkeyer:PORT, 50 for normal")
+	flags.BoolVarP(&visual, "visual", "", false, "If set show the Morse as flashes in the terminal as well")
+	flags.StringVarP(&visStyle, "visual-style", "", cwvisual.StyleBlock, "How to show the Morse in the terminal: "+strings.Join(cwvisual.Styles(), "|"))
+	flags.BoolVarP(&visLetters, "visual-letters", "", false, "If set show each letter in the terminal after its Morse")
+	flags.VarP(&unknown, "unknown", "", "What to do with characters with no Morse code: "+cw.UnknownPolicyNames("|"))
+}
+
+// levelValue is the --level flag.
+//
+// A Level of 0 means the default level in cw.Options so full scale
+// can't be asked for, and is rejected rather than silently giving
+// the default.
+type levelValue float64
+
+// String returns the level - for pflag.Value
+func (l *levelValue) String() string {
+	return strconv.FormatFloat(float64(*l), 'g', -1, 64)
+}
+
+// Set the level from a string - for pflag.Value
+func (l *levelValue) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	if v >= 0 {
+		return errors.New("must be below 0 dBFS, eg -0.1 for nearly full scale")
+	}
+	*l = levelValue(v)
+	return nil
+}
+
+// Type of the value - for pflag.Value
+func (l *levelValue) Type() string {
+	return "float"
 }
 
 // NewOpt creates a new set of cw.Options from the command line flags
 func NewOpt() *cw.Options {
 	return &cw.Options{
-		WPM:             wpm,
-		Farnsworth:      farnsworth,
-		Frequency:       frequency,
-		SampleRate:      sampleRate,
-		Channels:        channels,
-		BitDepthInBytes: bitDepthInBytes,
-		MaxSampleValue:  maxSampleValue,
-		OutputFile:      outputFile,
-		Debug:           cmd.Debug,
+		WPM:                 wpm,
+		Farnsworth:          farnsworth,
+		Frequency:           frequency,
+		SampleRate:          sampleRate,
+		Channels:            channels,
+		BitDepthInBytes:     bitDepthInBytes,
+		MaxSampleValue:      maxSampleValue,
+		Backend:             backend,
+		Device:              device,
+		Outputs:             outputs,
+		Force:               force,
+		Speaker:             speaker,
+		Format:              format,
+		Bitrate:             bitrate,
+		OutputBits:          bits,
+		Level:               float64(level),
+		Loudness:            loudness,
+		Debug:               cmd.Debug,
+		Unknown:             unknown,
+		Unknowns:            &cw.Unknowns{},
+		Cues:                cues,
+		BEXT:                bext,
+		Subtitles:           subtitles,
+		SubtitleGranularity: subGran,
+		SubtitleDelay:       subDelay,
+		Split:               split,
+		Playlist:            playlist,
+		KeyLine:             keyLine,
+		PTTLine:             pttLine,
+		PTTLead:             pttLead,
+		PTTTail:             pttTail,
+		Weighting:           weighting,
+		Visual:              visual,
+		VisualStyle:         visStyle,
+		VisualLetters:       visLetters,
 	}
 }
 
-// NewPlayer creates a new player from the options
+// NewPlayer creates a new player from the options.
+//
+// This sends the Morse to all the outputs in opt.Outputs, or the
+// speaker if there are none.
 func NewPlayer(opt *cw.Options) (cw.CW, error) {
-	if opt.OutputFile == "" {
-		return cwplayer.New(opt)
+	if opt.Level > 0 {
+		return nil, errors.New("--level must be below 0 dBFS")
+	}
+	outputs, err := parseOutputs(opt)
+	if err != nil {
+		return nil, err
+	}
+	// Make the options for all the outputs first so mistakes are
+	// found before any are opened. Each gets a copy of the options
+	// as they may adjust them. Unknown runes are only counted by the
+	// first.
+	opts := make([]*cw.Options, len(outputs))
+	for i, o := range outputs {
+		opts[i], err = o.options(opt)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			opts[i].Unknowns = nil
+		}
+	}
+	var cws []cw.CW
+	for i, o := range outputs {
+		c, err := o.newCW(opts[i])
+		if err != nil {
+			for _, c := range cws {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		cws = append(cws, c)
+	}
+	if len(cws) == 1 {
+		return cws[0], nil
+	}
+	return cw.NewTee(cws...), nil
+}
+
+// Stdout returns where text for the user should be written.
+//
+// This is normally os.Stdout but is os.Stderr when the audio is being
+// written to stdout so as not to corrupt it.
+func Stdout(opt *cw.Options) io.Writer {
+	return terminal(opt)
+}
+
+// terminal returns the terminal to show the Morse on visually, which
+// is the same as Stdout
+func terminal(opt *cw.Options) *os.File {
+	if toStdout(opt) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// LogUnknowns logs a summary of any characters which had no Morse code
+func LogUnknowns(opt *cw.Options) {
+	if summary := opt.Unknowns.Summary(); summary != "" {
+		log.Print(summary)
 	}
-	return cwfile.New(opt)
 }
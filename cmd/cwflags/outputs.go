@@ -0,0 +1,409 @@
+package cwflags
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwfile"
+	"github.com/ncw/cwtool/cwplayer"
+	"github.com/ncw/cwtool/cwrig"
+	"github.com/ncw/cwtool/cwserial"
+	"github.com/ncw/cwtool/cwvisual"
+)
+
+// kinds of output
+type outputKind int
+
+const (
+	kindFile outputKind = iota
+	kindSpeaker
+	kindTCP
+	kindSerial
+	kindWinKeyer
+	kindRig
+	kindVisual
+)
+
+// SpeakerScheme is the --out value to play on the speaker
+const SpeakerScheme = "speaker:"
+
+// Output describes a kind of output which --out can send the Morse to
+type Output struct {
+	Scheme      string   // prefix of the --out value, eg "serial:" - empty for a file
+	Usage       string   // how to write the --out value
+	Params      []string // parameters which can be set after a "?", eg "line"
+	Description string   // one line description
+	kind        outputKind
+	format      string // format to write a file in if set
+}
+
+// Outputs returns the kinds of output which --out accepts
+func Outputs() []*Output {
+	outputs := []*Output{
+		{
+			Scheme:      SpeakerScheme,
+			Usage:       "speaker:[device=NAME&backend=NAME]",
+			Params:      []string{"device", "backend"},
+			Description: "Play on the speaker - see cwtool devices",
+			kind:        kindSpeaker,
+		},
+		{
+			Usage:       "FILE or -",
+			Description: "Write a file in the format of its extension, or - for stdout - see cwtool formats",
+			kind:        kindFile,
+		},
+	}
+	for _, info := range cwfile.Encoders() {
+		outputs = append(outputs, &Output{
+			Scheme:      info.Name + ":",
+			Usage:       info.Name + ":FILE",
+			Description: "Write FILE, or - for stdout: " + info.Description,
+			kind:        kindFile,
+			format:      info.Name,
+		})
+	}
+	return append(outputs,
+		&Output{
+			Scheme:      cwfile.TCP,
+			Usage:       "tcp:HOST:PORT[?format=NAME]",
+			Params:      []string{"format"},
+			Description: "Stream audio to a TCP server, as WAV unless the format is set",
+			kind:        kindTCP,
+		},
+		&Output{
+			Scheme:      cwserial.Scheme,
+			Usage:       "serial:PORT[?line=dtr&ptt=rts&lead=50ms&tail=200ms]",
+			Params:      []string{"line", "ptt", "lead", "tail"},
+			Description: "Key a transmitter with a serial port line",
+			kind:        kindSerial,
+		},
+		&Output{
+			Scheme:      cwserial.WinKeyerScheme,
+			Usage:       "winkeyer:PORT[?weighting=50]",
+			Params:      []string{"weighting"},
+			Description: "Send with a WinKeyer on a serial port",
+			kind:        kindWinKeyer,
+		},
+		&Output{
+			Scheme:      cwrig.Scheme,
+			Usage:       "rigctld:[HOST:PORT]",
+			Description: "Send with a radio controlled by Hamlib's rigctld",
+			kind:        kindRig,
+		},
+		&Output{
+			Scheme:      cwvisual.Scheme,
+			Usage:       "visual:[?style=block&letters]",
+			Params:      []string{"style", "letters"},
+			Description: "Show the Morse as flashes in the terminal",
+			kind:        kindVisual,
+		},
+	)
+}
+
+// schemes returns the schemes of the outputs
+func schemes() (names []string) {
+	for _, kind := range Outputs() {
+		if kind.Scheme != "" {
+			names = append(names, kind.Scheme)
+		}
+	}
+	return names
+}
+
+// lookupOutput finds the kind of output with scheme, or nil if not found
+func lookupOutput(scheme string) *Output {
+	for _, kind := range Outputs() {
+		if kind.Scheme == scheme {
+			return kind
+		}
+	}
+	return nil
+}
+
+// output is a parsed --out value
+type output struct {
+	*Output
+	value  string     // the --out value as passed
+	arg    string     // the --out value without the scheme and parameters
+	params url.Values // parameters set after the "?"
+}
+
+// isScheme returns whether s is the name of a scheme.
+//
+// These are at least two letters or digits so a Windows drive letter
+// isn't taken as one.
+func isScheme(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOutput parses a --out value
+func parseOutput(value string) (*output, error) {
+	i := strings.IndexByte(value, ':')
+	if i < 0 || !isScheme(value[:i]) {
+		// A plain file
+		return &output{Output: lookupOutput(""), value: value, arg: value}, nil
+	}
+	scheme := strings.ToLower(value[:i+1])
+	kind := lookupOutput(scheme)
+	if kind == nil {
+		return nil, fmt.Errorf("unknown --out scheme %q in %q: must be one of %s - use ./%s for a file called that, see cwtool outputs", scheme, value, strings.Join(schemes(), ", "), value)
+	}
+	o := &output{Output: kind, value: value, arg: value[i+1:]}
+	if len(kind.Params) == 0 {
+		return o, nil
+	}
+	query := ""
+	if kind.kind == kindSpeaker {
+		// The parameters follow the scheme directly
+		query, o.arg = strings.TrimPrefix(o.arg, "?"), ""
+	} else if j := strings.LastIndexByte(o.arg, '?'); j >= 0 {
+		o.arg, query = o.arg[:j], o.arg[j+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("bad parameters in --out %q: %w", value, err)
+	}
+	for name := range params {
+		found := false
+		for _, param := range kind.Params {
+			found = found || name == param
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown parameter %q in --out %q: must be one of %s", name, value, strings.Join(kind.Params, ", "))
+		}
+	}
+	o.params = params
+	return o, nil
+}
+
+// param returns the value of the named parameter and whether it was set
+func (o *output) param(name string) (string, bool) {
+	values, found := o.params[name]
+	if !found || len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// badParam makes an error for a parameter with an invalid value
+func (o *output) badParam(name string, err error) error {
+	return fmt.Errorf("bad %s parameter in --out %q: %w", name, o.value, err)
+}
+
+// isFile returns whether the output writes audio with cwfile
+func (o *output) isFile() bool {
+	return o.kind == kindFile || o.kind == kindTCP
+}
+
+// options makes a copy of opt set up for the output
+func (o *output) options(opt *cw.Options) (*cw.Options, error) {
+	newOpt := *opt
+	newOpt.Outputs = nil
+	newOpt.OutputFile = o.Scheme + o.arg
+	if !o.isFile() {
+		newOpt.Subtitles = ""
+		newOpt.Split = ""
+	}
+	var err error
+	switch o.kind {
+	case kindSpeaker:
+		newOpt.OutputFile = ""
+		if device, found := o.param("device"); found {
+			newOpt.Device = device
+		}
+		if backend, found := o.param("backend"); found {
+			newOpt.Backend = backend
+		}
+	case kindFile:
+		newOpt.OutputFile = o.arg
+		if o.format != "" {
+			newOpt.Format = o.format
+		}
+		if newOpt.OutputFile == "" {
+			return nil, fmt.Errorf("need a file name in --out %q", o.value)
+		}
+	case kindTCP:
+		if format, found := o.param("format"); found {
+			newOpt.Format = format
+		} else if newOpt.Format == "" {
+			// The address has no extension to choose the format
+			newOpt.Format = "wav"
+		}
+	case kindSerial:
+		if line, found := o.param("line"); found {
+			newOpt.KeyLine = line
+		}
+		if ptt, found := o.param("ptt"); found {
+			newOpt.PTTLine = ptt
+		}
+		if lead, found := o.param("lead"); found {
+			newOpt.PTTLead, err = time.ParseDuration(lead)
+			if err != nil {
+				return nil, o.badParam("lead", err)
+			}
+		}
+		if tail, found := o.param("tail"); found {
+			newOpt.PTTTail, err = time.ParseDuration(tail)
+			if err != nil {
+				return nil, o.badParam("tail", err)
+			}
+		}
+	case kindWinKeyer:
+		if weighting, found := o.param("weighting"); found {
+			newOpt.Weighting, err = strconv.Atoi(weighting)
+			if err != nil {
+				return nil, o.badParam("weighting", err)
+			}
+		}
+	case kindVisual:
+		if style, found := o.param("style"); found {
+			newOpt.VisualStyle = style
+		}
+		if letters, found := o.param("letters"); found {
+			newOpt.VisualLetters = true
+			if letters != "" {
+				newOpt.VisualLetters, err = strconv.ParseBool(letters)
+				if err != nil {
+					return nil, o.badParam("letters", err)
+				}
+			}
+		}
+	}
+	return &newOpt, nil
+}
+
+// newCW makes the output with opt
+func (o *output) newCW(opt *cw.Options) (cw.CW, error) {
+	switch o.kind {
+	case kindSpeaker:
+		return cwplayer.New(opt)
+	case kindSerial:
+		return cwserial.New(opt)
+	case kindWinKeyer:
+		return cwserial.NewWinKeyer(opt)
+	case kindRig:
+		return cwrig.New(opt)
+	case kindVisual:
+		v, err := cwvisual.New(opt, terminal(opt))
+		if err != nil {
+			return nil, err
+		}
+		// Give the terminal back if interrupted
+		cmd.AtExit(func() {
+			_ = v.Restore()
+		})
+		return v, nil
+	}
+	if opt.Split != "" {
+		return cwfile.NewSplitter(opt)
+	}
+	return cwfile.New(opt)
+}
+
+// outputValues returns the --out values in opt.
+//
+// If opt.Outputs isn't set then opt.OutputFile is used if set.
+func outputValues(opt *cw.Options) []string {
+	if len(opt.Outputs) == 0 && opt.OutputFile != "" {
+		return []string{opt.OutputFile}
+	}
+	return opt.Outputs
+}
+
+// parseOutputs parses the outputs in opt, adding the speaker and the
+// visual display if they were asked for with flags, and checks the
+// flags make sense with them
+func parseOutputs(opt *cw.Options) (outputs []*output, err error) {
+	var speakers, files, visuals int
+	for _, value := range outputValues(opt) {
+		o, err := parseOutput(value)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	count := func() {
+		speakers, files, visuals = 0, 0, 0
+		for _, o := range outputs {
+			switch {
+			case o.kind == kindSpeaker:
+				speakers++
+			case o.kind == kindVisual:
+				visuals++
+			case o.isFile():
+				files++
+			}
+		}
+	}
+	count()
+	if len(outputs) == 0 || (opt.Speaker && speakers == 0) {
+		speaker, _ := parseOutput(SpeakerScheme)
+		outputs = append([]*output{speaker}, outputs...)
+	}
+	if opt.Visual {
+		if visuals > 0 {
+			return nil, errors.New("--visual can't be used with --out " + cwvisual.Scheme)
+		}
+		visual, _ := parseOutput(cwvisual.Scheme)
+		outputs = append(outputs, visual)
+	}
+	count()
+	if visuals > 1 {
+		return nil, errors.New("can only show the Morse visually once")
+	}
+	if speakers > 1 {
+		// The audio device and --device are set for the whole process
+		return nil, errors.New("can only play on one speaker - use --out " + SpeakerScheme + " once")
+	}
+	if files == 0 {
+		if opt.Subtitles != "" {
+			return nil, errors.New("--subtitles needs --out to be set to a file")
+		}
+		if opt.Split != "" {
+			return nil, errors.New("--split needs --out to be set to a file")
+		}
+		if opt.Format != "" {
+			return nil, errors.New("--format needs --out to be set to a file")
+		}
+		if opt.Loudness != 0 {
+			return nil, errors.New("--loudness needs --out to be set to a file")
+		}
+	}
+	if files > 1 && (opt.Split != "" || opt.Subtitles != "") {
+		return nil, errors.New("--split and --subtitles can only be used with one file in --out")
+	}
+	if speakers == 0 {
+		if opt.Device != "" {
+			return nil, errors.New("--device needs --speaker to be used with --out")
+		}
+		if opt.Backend != "" {
+			return nil, errors.New("--backend needs --speaker to be used with --out")
+		}
+	}
+	return outputs, nil
+}
+
+// toStdout returns whether any of the outputs in opt write to stdout
+func toStdout(opt *cw.Options) bool {
+	for _, value := range outputValues(opt) {
+		o, err := parseOutput(value)
+		if err == nil && o.kind == kindFile && o.arg == cwfile.Stdout {
+			return true
+		}
+	}
+	return false
+}
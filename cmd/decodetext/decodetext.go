@@ -0,0 +1,115 @@
+// Package decodetext provides the decode-text command
+package decodetext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	file  string
+	stdin bool
+)
+
+// subCmd represents the decode-text command
+var subCmd = &cobra.Command{
+	Use:   "decode-text",
+	Short: "Turn dots and dashes back into text",
+	Long: strings.ReplaceAll(`
+
+This turns Morse code written as dots and dashes from the command
+line, or from a file with the |--file| flag or from stdin with the
+|--stdin| flag, back into text.
+
+Letters should be separated by spaces and words by |/| or a new line,
+so
+
+    cwtool decode-text ".... . .-.. .-.. --- / .-- --- .-. .-.. -.."
+
+writes |HELLO WORLD|.
+
+Dots may be written as any of |`+cwgenerator.DecodeDots+`| and dashes
+as any of |`+cwgenerator.DecodeDashes+`| so the output of |cwtool
+encode --unicode| can be read back in too.
+
+The error prosign HH (eight dots), as written by |cwtool encode
+--unknown hh|, is decoded as |`+cwgenerator.DecodedHH+`|.
+
+Any codes which can't be decoded are written as |�| and reported as
+errors.
+
+`, "|", "`"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(args)
+	},
+}
+
+func init() {
+	cmd.Root.AddCommand(subCmd)
+	flags := subCmd.Flags()
+	flags.StringVarP(&file, "file", "", "", "File to decode (optional)")
+	flags.BoolVarP(&stdin, "stdin", "", false, "If set decode stdin")
+}
+
+// Decode s and print it, returning the number of errors
+func decode(s string) (errors int) {
+	text, err := cwgenerator.Decode(s)
+	if text != "" || err == nil {
+		fmt.Println(text)
+	}
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// Decode each line of in, returning the number of errors
+func decodeLines(in io.Reader) (errors int, err error) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		errors += decode(scanner.Text())
+	}
+	return errors, scanner.Err()
+}
+
+func run(args []string) error {
+	errors := 0
+	if len(args) > 0 {
+		errors += decode(strings.Join(args, " "))
+	}
+
+	if file != "" {
+		in, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open file to decode: %w", err)
+		}
+		n, err := decodeLines(in)
+		_ = in.Close()
+		errors += n
+		if err != nil {
+			return fmt.Errorf("failed to decode file: %w", err)
+		}
+	}
+
+	if stdin {
+		n, err := decodeLines(os.Stdin)
+		errors += n
+		if err != nil {
+			return fmt.Errorf("failed to decode stdin: %w", err)
+		}
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("failed to decode %d lines", errors)
+	}
+	return nil
+}
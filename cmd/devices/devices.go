@@ -0,0 +1,56 @@
+// Package devices provides the devices command
+package devices
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cwplayer"
+	"github.com/spf13/cobra"
+)
+
+// subCmd represents the devices command
+var subCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the audio output devices",
+	Long: strings.ReplaceAll(`
+
+This lists the audio output devices which Morse can be played to.
+
+Pass the name in the first column to |--device| to play to that device
+instead of the default, eg a USB headset or the sound card interface
+of a rig. The name of the card on its own, or its number, can be used
+to play to the first device on the card.
+
+    cwtool play --device Device,0 "CQ CQ"
+
+On Linux this sets the card used by the ALSA |default| device so it
+won't work if |default| has been configured to use a sound server such
+as PulseAudio or PipeWire. Other platforms only support the default
+device.
+
+`, "|", "`"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run()
+	},
+}
+
+func init() {
+	cmd.Root.AddCommand(subCmd)
+}
+
+func run() error {
+	devices, err := cwplayer.Devices()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tDESCRIPTION\n")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\n", d.Name, d.Description)
+	}
+	return w.Flush()
+}
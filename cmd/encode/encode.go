@@ -0,0 +1,130 @@
+// Package encode provides the encode command
+package encode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/ncw/cwtool/cmd/textflags"
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/ncw/cwtool/cwtext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	file     string
+	stdin    bool
+	unicode  bool
+	unknown  cw.UnknownPolicy
+	notation cwgenerator.Notation
+)
+
+// subCmd represents the encode command
+var subCmd = &cobra.Command{
+	Use:   "encode",
+	Short: "Write text as dots and dashes",
+	Long: strings.ReplaceAll(`
+
+This writes text from the command line, or from a file with the
+|--file| flag or from stdin with the |--stdin| flag, as Morse code in
+dots and dashes.
+
+Letters are separated by a space and words by | / |, so |cwtool encode
+hello world| writes
+
+    .... . .-.. .-.. --- / .-- --- .-. .-.. -..
+
+Use |--unicode| to write the dots and dashes as |·| and |−| which look
+better in printed material.
+
+The text is normalised first according to the |--normalise| flag.
+
+Use |cwtool decode-text| to turn dots and dashes back into text.
+
+`, "|", "`"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(args)
+	},
+}
+
+func init() {
+	cmd.Root.AddCommand(subCmd)
+	flags := subCmd.Flags()
+	textflags.Add(flags)
+	flags.StringVarP(&file, "file", "", "", "File to encode (optional)")
+	flags.BoolVarP(&stdin, "stdin", "", false, "If set encode stdin")
+	flags.BoolVarP(&unicode, "unicode", "", false, "If set use Unicode · and − for dots and dashes")
+	flags.VarP(&unknown, "unknown", "", "What to do with characters with no Morse code: "+cw.UnknownPolicyNames("|"))
+}
+
+// Encode s and print it
+func encode(opt *cw.Options, n *cwtext.Normaliser, s string) error {
+	code, err := notation.Encode(opt, n.Normalise(s))
+	if err != nil {
+		return err
+	}
+	fmt.Println(code)
+	return nil
+}
+
+// Encode each line of in
+func encodeLines(opt *cw.Options, n *cwtext.Normaliser, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		err := encode(opt, n, scanner.Text())
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func run(args []string) error {
+	n, err := textflags.NewNormaliser()
+	if err != nil {
+		return err
+	}
+	opt := &cw.Options{
+		Unknown:  unknown,
+		Unknowns: &cw.Unknowns{},
+	}
+	notation = cwgenerator.ASCIINotation
+	if unicode {
+		notation = cwgenerator.UnicodeNotation
+	}
+
+	if len(args) > 0 {
+		err = encode(opt, n, strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	if file != "" {
+		in, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open file to encode: %w", err)
+		}
+		err = encodeLines(opt, n, in)
+		_ = in.Close()
+		if err != nil {
+			return fmt.Errorf("failed to encode file: %w", err)
+		}
+	}
+
+	if stdin {
+		err = encodeLines(opt, n, os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to encode stdin: %w", err)
+		}
+	}
+
+	cwflags.LogUnknowns(opt)
+	return nil
+}
@@ -0,0 +1,64 @@
+// Package formats provides the formats command
+package formats
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cwfile"
+	"github.com/spf13/cobra"
+)
+
+// subCmd represents the formats command
+var subCmd = &cobra.Command{
+	Use:   "formats",
+	Short: "List the output file formats",
+	Long: strings.ReplaceAll(`
+
+This lists the formats which can be written with |--out|.
+
+The format is chosen from the extension of the |--out| file, or can be
+set with |--format| using the name in the first column. Formats which
+only support some sample rates will adjust |--samplerate| to the
+nearest one, and likewise for |--bits|.
+
+Programs using cwtool as a library can add their own formats with
+|cwfile.Register| and they will be listed here.
+
+`, "|", "`"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run()
+	},
+}
+
+func init() {
+	cmd.Root.AddCommand(subCmd)
+}
+
+func run() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tEXTENSIONS\tSAMPLE RATES\tBITS\tDESCRIPTION\n")
+	for _, info := range cwfile.Encoders() {
+		exts := info.Extensions
+		if len(exts) == 0 {
+			exts = []string{info.Name}
+		}
+		fmt.Fprintf(w, "%s\t.%s\t%s\t%s\t%s\n", info.Name, strings.Join(exts, " ."), list(info.SampleRates, "any"), list(info.Bits, "8,16,24,32"), info.Description)
+	}
+	return w.Flush()
+}
+
+// list returns the values joined with commas, or all if there are none
+func list(values []int, all string) string {
+	if len(values) == 0 {
+		return all
+	}
+	var ss []string
+	for _, v := range values {
+		ss = append(ss, fmt.Sprint(v))
+	}
+	return strings.Join(ss, ",")
+}
@@ -19,11 +19,14 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	//evdev "github.com/holoplot/go-evdev"
 	evdev "github.com/gvalkov/golang-evdev"
 	"github.com/ncw/cwtool/cmd"
 	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/ncw/cwtool/cmd/textflags"
+	"github.com/ncw/cwtool/cwgenerator"
 	"github.com/spf13/cobra"
 )
 
@@ -63,6 +66,10 @@ with an |A| button!).
 
 Use |--wpm| to set the words per minute of the Morse code generated.
 
+Each key pressed is normalised according to the |--normalise| flag
+before being sent, so for example |&| is sent as |AND|. Use
+|--cut-numbers| to send digits as cut numbers.
+
 For example to play all keypresses at 30 WPM
 
     cwtool keymorse --wpm 30
@@ -77,6 +84,7 @@ func init() {
 	cmd.Root.AddCommand(subCmd)
 	flags := subCmd.Flags()
 	cwflags.Add(flags)
+	textflags.AddSingle(flags)
 	flags.BoolVarP(&logger, "logger", "", false, "Set this to start the logger (done automatically)")
 	_ = flags.MarkHidden("logger")
 }
@@ -253,6 +261,10 @@ func runLogger(args []string) error {
 // Read keys from in and send Morse
 func runMorser(in io.Reader) error {
 	bufIn := bufio.NewReader(in)
+	n, err := textflags.NewNormaliser()
+	if err != nil {
+		return err
+	}
 	opt := cwflags.NewOpt()
 	opt.Continuous = true
 	opt.Title = "Keystrokes as Morse Code"
@@ -271,22 +283,36 @@ func runMorser(in io.Reader) error {
 	fmt.Fprintf(os.Stderr, "\nListening for keys pressed to send Morse.\n")
 	for {
 		c, _, err := bufIn.ReadRune()
-		if c < 0x20 {
-			continue
-		}
 		if err == io.EOF {
 			break
 		} else if err != nil {
+			_ = cw.Close()
 			return err
 		}
+		if c < 0x20 {
+			continue
+		}
 		debugf("Rx: %c", c)
-		cw.Rune(c)
+		if unicode.IsSpace(c) {
+			// Normalising would remove a space on its own
+			cw.Rune(' ')
+			continue
+		}
+		s := n.Normalise(string(c))
+		// With --unknown error keys with no Morse code are
+		// reported and not sent
+		err = cwgenerator.Check(opt, s)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		cw.String(s)
 	}
-	return nil
+	return cw.Close()
 }
 
 func start() error {
-	fmt.Println("Starting keyboard listener as root.")
+	fmt.Fprintln(os.Stderr, "Starting keyboard listener as root.")
 	binary, err := exec.LookPath(os.Args[0])
 	if err != nil {
 		return fmt.Errorf("failed to find path for %q: %w", os.Args[0], err)
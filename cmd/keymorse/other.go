@@ -1,3 +1,4 @@
+//go:build !linux
 // +build !linux
 
 // Empty build to stop go build complaining
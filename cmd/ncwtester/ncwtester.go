@@ -12,6 +12,8 @@ import (
 	"github.com/fatih/color"
 	"github.com/ncw/cwtool/cmd"
 	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/ncw/cwtool/cwtext"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
@@ -21,6 +23,7 @@ var (
 	timeCutoff time.Duration
 	letters    string
 	group      int
+	cutNumbers string
 )
 
 // subCmd represents the ncwtester command
@@ -46,6 +49,15 @@ All stats are stored in the file specified by |--log| for analysis.
 
 Setting |--group| can send multiple characters at once - it waits for
 them all to be received before carrying on.
+
+Setting |--cut-numbers| sends the digits given as contest style cut
+numbers, eg |T| for |0| and |N| for |9|. Use |all| for all the digits
+which have cut numbers. You should answer with the digit, not the
+letter you heard.
+
+The |--letters| are sent as they are rather than being normalised like
+the text in the other commands, since the answer must match what was
+sent.
 `, "|", "`"),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return run()
@@ -60,6 +72,7 @@ func init() {
 	flags.DurationVarP(&timeCutoff, "cutoff", "", 0, "If set, ignore stats older than this")
 	flags.StringVarP(&letters, "letters", "", "abcdefghijklmnopqrstuvwxyz0123456789.=/,?", "Letters to test")
 	flags.IntVarP(&group, "group", "", 1, "Send letters in groups this big")
+	flags.StringVarP(&cutNumbers, "cut-numbers", "", "", "Send these digits as cut numbers, eg 09 or all")
 }
 
 func shuffleString(s string) string {
@@ -106,8 +119,8 @@ func yorn(prompt string) bool {
 		if c == 'y' || c == 'n' {
 			break
 		} else if isExit(c) {
-			fmt.Println("...bye\n")
-			os.Exit(0)
+			fmt.Print("...bye\n\n")
+			cmd.Exit(0)
 		}
 	}
 	fmt.Println(string(c))
@@ -119,12 +132,27 @@ func ms(t time.Duration) int64 {
 	return t.Milliseconds()
 }
 
-func run() error {
+func run() (err error) {
+	cutDigits, err := cwtext.ParseCutDigits(cutNumbers)
+	if err != nil {
+		return err
+	}
+
 	opt := cwflags.NewOpt()
+	err = cwgenerator.Check(opt, letters)
+	if err != nil {
+		return fmt.Errorf("bad --letters: %w", err)
+	}
 	cw, err := cwflags.NewPlayer(opt)
 	if err != nil {
 		return fmt.Errorf("failed to make cw player: %w", err)
 	}
+	defer func() {
+		closeErr := cw.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
 
 	csvLog := NewCSVLog(logFile)
 	sessionStats := NewStats()
@@ -149,7 +177,10 @@ outer:
 		}
 
 		cw.String(" vvv   ")
-		cw.Sync()
+		err = cw.Sync()
+		if err != nil {
+			return err
+		}
 
 		roundStats := NewStats()
 
@@ -158,11 +189,14 @@ outer:
 			if i%group == 0 {
 				cw.Rune(' ')
 				for j := i; j < i+group; j++ {
-					cw.Rune(rune(testLetters[j]))
+					cw.Rune(cwtext.CutRune(rune(testLetters[j]), cutDigits))
 				}
 				// cwDuration := cw.duration()
 				// startPlaying := time.Now()
-				cw.Sync()
+				err = cw.Sync()
+				if err != nil {
+					return err
+				}
 			}
 			finishedPlaying := time.Now()
 			// fmt.Printf("time to play %dms, expected %dms, diff=%dms\n", ms(finishedPlaying.Sub(startPlaying)), ms(cwDuration), ms(finishedPlaying.Sub(startPlaying)-cwDuration))
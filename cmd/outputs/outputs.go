@@ -0,0 +1,58 @@
+// Package outputs provides the outputs command
+package outputs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ncw/cwtool/cmd"
+	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/spf13/cobra"
+)
+
+// subCmd represents the outputs command
+var subCmd = &cobra.Command{
+	Use:   "outputs",
+	Short: "List the outputs the Morse can be sent to",
+	Long: strings.ReplaceAll(`
+
+This lists the outputs which can be used with |--out|.
+
+|--out| may be given more than once to send the Morse to several
+outputs at the same time. Each is written as in the USAGE column. The
+parameters after the |?| are optional and override the flags of the
+same meaning for that output only, eg
+
+    --out "serial:/dev/ttyUSB0?line=rts&ptt=dtr" --out practice.wav
+
+Anything without a scheme is a file, so use |./name:with:colons.wav|
+for a file with a colon in its name.
+
+`, "|", "`"),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run()
+	},
+}
+
+func init() {
+	cmd.Root.AddCommand(subCmd)
+}
+
+func run() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SCHEME\tUSAGE\tPARAMETERS\tDESCRIPTION\n")
+	for _, output := range cwflags.Outputs() {
+		scheme := output.Scheme
+		if scheme == "" {
+			scheme = "-"
+		}
+		params := strings.Join(output.Params, ",")
+		if params == "" {
+			params = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", scheme, output.Usage, params, output.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,257 @@
+package play
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/ncw/cwtool/cwplayer"
+	"github.com/ncw/cwtool/cwtext"
+	"golang.org/x/term"
+)
+
+const (
+	wpmStep       = 2   // WPM change for each key press
+	minWPM        = 5   // slowest speed
+	frequencyStep = 50  // Hz change for each key press
+	minFrequency  = 200 // lowest pitch
+	replayDits    = 2   // replay the previous word if fewer dits of the current one have been heard
+	statusEvery   = 100 * time.Millisecond
+)
+
+// transport controls the playing of the lines interactively
+type transport struct {
+	opt       *cw.Options
+	player    *cwplayer.Player
+	lines     [][]string // words of each line
+	line      int        // index of the line being played
+	starts    []int      // dit position each word of the line starts at
+	wpm       float64    // current speed
+	frequency float64    // current pitch
+}
+
+// Read the lines to play from the arguments and the file
+func readLines(opt *cw.Options, n *cwtext.Normaliser, args []string) (lines [][]string, err error) {
+	add := func(s string) error {
+		s = n.Normalise(s)
+		if s == "" {
+			return nil
+		}
+		err := cwgenerator.Check(opt, s)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, strings.Fields(s))
+		return nil
+	}
+	for _, arg := range args {
+		err = add(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if file != "" {
+		in, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file to play: %w", err)
+		}
+		defer func() {
+			_ = in.Close()
+		}()
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			err = add(scanner.Text())
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = scanner.Err()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file to play: %w", err)
+		}
+	}
+	return lines, nil
+}
+
+// Read key presses from stdin into the channel returned until stop
+// is closed, closing it at EOF or when stopped
+func readKeys(stop <-chan struct{}) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		var buf [1]byte
+		for waitKey(stop) {
+			n, err := os.Stdin.Read(buf[:])
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				select {
+				case keys <- buf[0]:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return keys
+}
+
+// Play the lines with keyboard controls
+func playInteractive(opt *cw.Options, player *cwplayer.Player, n *cwtext.Normaliser, args []string) error {
+	lines, err := readLines(opt, n, args)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("--interactive needs stdin to be a terminal")
+	}
+	fmt.Print("Keys: space pause/resume, w replay word, l replay line, n next line,\n")
+	fmt.Print("      +/- speed, ]/[ pitch, q quit\n\n")
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to read the keyboard: %w", err)
+	}
+	defer func() {
+		_ = term.Restore(fd, state)
+		fmt.Print("\n")
+	}()
+
+	t := &transport{
+		opt:       opt,
+		player:    player,
+		lines:     lines,
+		wpm:       opt.WPM,
+		frequency: opt.Frequency,
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	keys := readKeys(stop)
+	ticker := time.NewTicker(statusEvery)
+	defer ticker.Stop()
+	t.startLine(0)
+	for {
+		t.status()
+		select {
+		case <-player.Done():
+			if !t.startLine(t.line + 1) {
+				return nil
+			}
+		case key, ok := <-keys:
+			if !ok {
+				// Carry on without the keyboard
+				keys = nil
+				break
+			}
+			if !t.key(key) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// startLine starts playing line i, returning false if there are no
+// more lines
+func (t *transport) startLine(i int) bool {
+	if i >= len(t.lines) {
+		return false
+	}
+	t.line = i
+	t.starts = make([]int, len(t.lines[i]))
+	fmt.Printf("\r\033[K%s\r\n", strings.Join(t.lines[i], " "))
+	t.queue(0)
+	return true
+}
+
+// queue the current line from word i onwards followed by a BT,
+// replacing anything which hasn't been played yet
+func (t *transport) queue(i int) {
+	t.player.Clear()
+	for ; i < len(t.starts); i++ {
+		_, t.starts[i] = t.player.Position()
+		t.player.String(t.lines[t.line][i])
+		t.player.Rune(' ')
+	}
+	t.player.String("= ")
+}
+
+// word returns the index of the word being heard and how many dits of
+// it have been heard
+func (t *transport) word() (i, dits int) {
+	heard, _ := t.player.Position()
+	for i+1 < len(t.starts) && t.starts[i+1] <= heard {
+		i++
+	}
+	return i, heard - t.starts[i]
+}
+
+// key acts on a key press, returning false to quit
+func (t *transport) key(key byte) bool {
+	switch key {
+	case ' ':
+		if t.player.Paused() {
+			t.player.Resume()
+		} else {
+			t.player.Pause()
+		}
+	case 'w', 'W':
+		i, dits := t.word()
+		if dits < replayDits && i > 0 {
+			i--
+		}
+		t.queue(i)
+	case 'l', 'L':
+		t.queue(0)
+	case 'n', 'N':
+		return t.startLine(t.line + 1)
+	case '+', '=':
+		t.reconfigure(t.wpm+wpmStep, t.frequency)
+	case '-', '_':
+		t.reconfigure(t.wpm-wpmStep, t.frequency)
+	case ']':
+		t.reconfigure(t.wpm, t.frequency+frequencyStep)
+	case '[':
+		t.reconfigure(t.wpm, t.frequency-frequencyStep)
+	case 'q', 'Q', 3, 4: // Ctrl-C, Ctrl-D
+		return false
+	}
+	return true
+}
+
+// reconfigure sets the speed and pitch within limits
+func (t *transport) reconfigure(wpm, frequency float64) {
+	if wpm < minWPM {
+		wpm = minWPM
+	}
+	// Keep well below the Nyquist frequency
+	maxFrequency := float64(t.opt.SampleRate / 4)
+	if frequency > maxFrequency {
+		frequency = maxFrequency
+	}
+	if frequency < minFrequency {
+		frequency = minFrequency
+	}
+	t.wpm, t.frequency = wpm, frequency
+	t.player.Reconfigure(wpm, frequency)
+}
+
+// status shows the position and settings on the current line
+func (t *transport) status() {
+	i, _ := t.word()
+	paused := ""
+	if t.player.Paused() {
+		paused = " [paused]"
+	}
+	fmt.Printf("\r\033[KLine %d/%d Word %d/%d %.0f WPM %.0f Hz%s",
+		t.line+1, len(t.lines), i+1, len(t.starts), t.wpm, t.frequency, paused)
+}
@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package play
+
+// waitKey waits until a key press can be read from stdin, returning
+// false if stop is closed first.
+//
+// Stdin can't be polled here so this only checks stop and the reader
+// stops after the next key press instead.
+func waitKey(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,34 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package play
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// How long to wait for a key press before checking for stop in ms
+const keyPoll = 50
+
+// waitKey waits until a key press can be read from stdin, returning
+// false if stop is closed first
+func waitKey(stop <-chan struct{}) bool {
+	fds := []unix.PollFd{{Fd: int32(os.Stdin.Fd()), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		n, err := unix.Poll(fds, keyPoll)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n > 0 {
+			// Let the read return any error
+			return true
+		}
+	}
+}
@@ -1,19 +1,28 @@
 package play
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
-	"regexp"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/ncw/cwtool/cmd"
 	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/ncw/cwtool/cmd/textflags"
 	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwfile"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/ncw/cwtool/cwplayer"
+	"github.com/ncw/cwtool/cwtext"
 	"github.com/spf13/cobra"
 )
 
 var (
-	file  string
-	stdin bool
+	file        string
+	stdin       bool
+	interactive bool
 )
 
 // subCmd represents the rss ommand
@@ -25,6 +34,131 @@ var subCmd = &cobra.Command{
 This plays Morse code from the command line or from a file with the
 |--file| flag or from stdin with the |--stdin| flag.
 
+Each line of the file is played followed by a |BT|.
+
+Use |--split item| with |--out| to write each line to its own numbered
+file with a playlist and an answer key, or |--split paragraph| to write
+each paragraph of the file, separated by blank lines, to its own file
+named after its first line. Use |--split 5m| to start a new file every
+5 minutes or so.
+
+The text is normalised before being played according to the
+|--normalise| flag.
+
+Use |--abbreviate| to send common words and phrases as the
+abbreviations used on air, eg |ES| for "and" and |WX| for "weather".
+
+Use |--speaker| with |--out| to hear the Morse as well as writing the
+file. Any pauses between the Morse being sent, eg while waiting for
+typing in |keymorse| or answers in |ncwtester|, are recorded in the
+file too so it sounds as it was heard.
+
+Use |--backend null| to play without an audio device, eg on a server
+or in scripted tests. The Morse still takes as long to play as it
+would on the speaker.
+
+Use |--backend capture:FILE| to record what would have been played to
+FILE as raw signed 16 bit little endian PCM. This is written like an
+|--out| file so |--force| is needed to overwrite an existing FILE.
+
+The backend can also be set with the |CWTOOL_BACKEND| environment
+variable so it applies to all the commands.
+
+Files written with |--out| are written to a temporary file which is
+renamed into place only when complete, so an interrupted or failed run
+never leaves a partial file behind. An existing file won't be
+overwritten unless |--force| is given.
+
+Use |--out -| to write the audio to stdout so it can be piped into
+another program. This is a WAV stream by default, or use |--format raw|
+for headerless signed little endian PCM with the channels interleaved
+at the |--samplerate| and |--bits| per sample, 16 by default, eg
+
+    cwtool play --out - --format raw "CQ CQ" | aplay -f S16_LE -r 8000
+
+The text being played is written to stderr rather than stdout when
+doing this.
+
+Use |--out serial:/dev/ttyUSB0| to key a transmitter or practice
+oscillator with the DTR line of a serial port, as many logging programs
+do. Use |--key-line rts| to key with the RTS line instead, and
+|--ptt-line| to switch PTT with the other line, turning it on
+|--ptt-lead| before the Morse starts and off |--ptt-tail| after it
+ends. Add |--speaker| to hear the Morse as well.
+
+Use |--out winkeyer:/dev/ttyUSB0| to send the Morse with a K1EL
+WinKeyer (WK2 or WK3). It is set to the |--wpm|, |--farnsworth| and
+|--weighting| and sends the Morse with its own timing. Characters it
+doesn't have are sent by merging two it does, eg HH as two Hs.
+
+Use |--out rigctld:localhost:4532| to have a radio send the Morse
+itself, controlled by Hamlib's |rigctld|. The keyer speed is set to
+the |--wpm| and the text is sent with the |send_morse| command. This
+works with |rss| and the other commands too. The radio can't send the
+HH prosign so |--unknown hh| can't be used with it.
+
+Use |--visual| to see the Morse as well as hearing it, as flashes on
+the bottom line of the terminal, or |--out visual:| to only see it.
+The whole line lights up while the key is down, or use
+|--visual-style bar| to show the keying as a coloured bar scrolling
+along the line. Add |--visual-letters| to show each letter after its
+Morse. This works with all the commands.
+
+|--out| may be given more than once to send the Morse to several
+outputs at once. Each is a file or a URI naming the output, with
+parameters after a |?| overriding the flags for that output only, eg
+
+    cwtool play --out speaker: --out practice.mp3 --out "serial:/dev/ttyUSB0?line=rts" "CQ CQ"
+
+Use |--out speaker:device=USB| to choose the audio device, |--out
+wav:FILE| to set the format of a file and |--out tcp:HOST:PORT| to
+stream the audio to a TCP server. See |cwtool outputs| for them all.
+Only one speaker can be played on at once.
+
+Use |--out file.mid| to write a MIDI file with a note for each dit and
+dah instead of audio. A dit is a sixteenth note at the |--frequency|
+and the tempo is set from the |--wpm|. To write the MIDI file alongside
+the audio give both, eg
+
+    cwtool play --out practice.wav --out practice.mid "CQ CQ"
+
+The Morse peaks at |--level| dBFS, about -10.5 by default. Use a
+small negative level like |--level -0.1| to get close to full scale.
+
+Use |--loudness| to normalise an |--out| file to an integrated
+loudness instead so it sits at the same volume as other audio, eg
+|--loudness -16| for podcasts or |--loudness -23| for broadcast.
+
+The whole file is measured before it is written, and the peaks are
+kept below -1 dBFS even if this means the target can't be reached.
+
+Use |--bits| to set the bits per sample of |--out| files. TPDF dither
+is added when this or |--loudness| reduces the resolution of the
+samples.
+
+Use |--interactive| to control the playing from the keyboard, eg to
+study a file of practice text. The current line, word, speed and pitch
+are shown on a status line. The keys are
+
+- |space| pause or resume
+- |w| replay the word just heard
+- |l| replay the line from the start
+- |n| skip to the next line
+- |+| and |-| change the speed by 2 WPM
+- |]| and |[| change the pitch by 50 Hz
+- |q| quit
+
+Use |--subtitles| to write the text as subtitles alongside the |--out|
+file so media players show it as the Morse plays, eg
+
+    cwtool play --file practice.txt --out practice.mp3 --subtitles practice.srt --subtitles-delay 3s
+
+The subtitles can be SRT, WebVTT or LRC files, and show an item
+(line), word or character at a time according to
+|--subtitles-granularity|. Use |--subtitles-delay| to show the text only
+after it has been heard - each subtitle appears that long after the
+end of its Morse.
+
 `, "|", "`"),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return run(args)
@@ -35,41 +169,111 @@ func init() {
 	cmd.Root.AddCommand(subCmd)
 	flags := subCmd.Flags()
 	cwflags.Add(flags)
+	textflags.Add(flags)
 	flags.StringVarP(&file, "file", "", "", "File to play Morse from (optional)")
 	flags.BoolVarP(&stdin, "stdin", "", false, "If set play Morse from stdin")
+	flags.BoolVarP(&interactive, "interactive", "", false, "If set control the playing from the keyboard")
 }
 
-var (
-	removeInvalid = regexp.MustCompile(`[^A-Za-z0-9,.: /]+`)
-)
-
-// Simplify and play the string
-func play(cw cw.CW, s string) {
-	// Remove all unknown characters
-	s = removeInvalid.ReplaceAllString(s, "")
-
-	// Replace `:` with more CW friendly ` =`
-	s = strings.ReplaceAll(s, ":", " =")
-
-	fmt.Println(s)
-	cw.String(s)
-	cw.String(" = ")
-	cw.Sync()
+// Play each line of in.
+//
+// With --split paragraph the start of each paragraph is marked as an
+// item named after its first line.
+func playLines(opt *cw.Options, cw cw.CW, n *cwtext.Normaliser, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	paragraph := 0
+	blank := true // set if the previous line was blank
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			blank = true
+			continue
+		}
+		if blank && opt.Split == cwfile.SplitParagraph {
+			paragraph++
+			textflags.Item(cw, paragraph, n.Normalise(line))
+		}
+		blank = false
+		err := textflags.Play(opt, cw, n, line)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
-func run(args []string) error {
+func run(args []string) (err error) {
+	n, err := textflags.NewNormaliser()
+	if err != nil {
+		return err
+	}
+
 	opt := cwflags.NewOpt()
 	opt.Title = strings.Join(args, " ")
+	if opt.Title == "" {
+		opt.Title = file
+	}
+	if interactive {
+		if stdin {
+			return errors.New("--interactive can't be used with --stdin")
+		}
+		if len(opt.Outputs) > 0 {
+			return errors.New("--interactive can't be used with --out")
+		}
+		if opt.Visual {
+			return errors.New("--interactive can't be used with --visual")
+		}
+	}
 	cw, err := cwflags.NewPlayer(opt)
 	if err != nil {
 		return fmt.Errorf("failed to make cw player: %w", err)
 	}
+	// Always close the player so outputs release the radio and
+	// temporary files are tidied up
+	defer func() {
+		err = errors.Join(err, cw.Close())
+		cwflags.LogUnknowns(opt)
+	}()
+
+	if interactive {
+		player, ok := cw.(*cwplayer.Player)
+		if !ok {
+			return errors.New("--interactive can only be used with the speaker")
+		}
+		return playInteractive(opt, player, n, args)
+	}
 
 	for _, arg := range args {
+		arg = n.Normalise(arg)
+		err = cwgenerator.Check(opt, arg)
+		if err != nil {
+			return err
+		}
 		cw.String(arg)
 		cw.Rune(' ')
-		cw.Sync()
+		err = cw.Sync()
+		if err != nil {
+			return fmt.Errorf("failed to play: %w", err)
+		}
 	}
 
-	return cw.Close()
+	if file != "" {
+		in, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open file to play: %w", err)
+		}
+		err = playLines(opt, cw, n, in)
+		_ = in.Close()
+		if err != nil {
+			return fmt.Errorf("failed to play file: %w", err)
+		}
+	}
+
+	if stdin {
+		err = playLines(opt, cw, n, os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to play stdin: %w", err)
+		}
+	}
+	return nil
 }
@@ -1,15 +1,16 @@
 package rss
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/ncw/cwtool/cmd"
 	"github.com/ncw/cwtool/cmd/cwflags"
-	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cmd/textflags"
+	"github.com/ncw/cwtool/cwtext"
 	"github.com/spf13/cobra"
 )
 
@@ -55,11 +56,36 @@ in the feed.
 Use |--description| to add the descriptions of each link in as well as
 their titles.
 
+Use |--abbreviate| to send common words and phrases as the
+abbreviations used on air, eg |ES| for "and" and |WX| for "weather",
+which makes the news sound more like a QSO and shortens long items.
+
 For example to play the BBC UK News to a file at 20 WPM but with 8 WPM
 Farnsworth spacing:
 
     cwtool rss -v --url http://feeds.bbci.co.uk/news/uk/rss.xml --wpm 20 --farnsworth 8 --out bbc.wav
 
+Use |--out bbc.flac| instead to write a smaller lossless FLAC file, or
+|--out bbc.mp3| to write an MP3 file for phones and podcast players.
+The MP3 bitrate can be set with |--bitrate|.
+
+Use |--split item| to write each item to its own file numbered with
+its NR and named after its title, eg |bbc-001-some-headline.mp3|,
+along with a playlist |bbc.m3u| and an answer key |bbc.txt|, so items
+can be skipped or repeated on a simple player.
+
+The title and description of the feed go in a file of their own
+numbered 000. Each item is a paragraph so |--split paragraph| does the
+same.
+
+Use |--split 5m| instead to start a new file at the end of the first
+item after 5 minutes.
+
+Use |--cues item| to mark where each item starts in a .wav file with a
+labelled cue point, so the file can be used as an answer key in
+Audacity or a DAW. Use |--cues word| or |--cues char| to mark each word
+or character instead.
+
 `, "|", "`"),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return run()
@@ -70,33 +96,21 @@ func init() {
 	cmd.Root.AddCommand(subCmd)
 	flags := subCmd.Flags()
 	cwflags.Add(flags)
+	textflags.Add(flags)
 	flags.StringVarP(&url, "url", "", "", "URL to fetch RSS from")
 	flags.BoolVarP(&description, "description", "", false, "If set add the description too")
 }
 
-var (
-	removeInvalid = regexp.MustCompile(`[^A-Za-z0-9,.: /]+`)
-)
-
-// Simplify and play the string
-func play(cw cw.CW, s string) {
-	// Remove all unknown characters
-	s = removeInvalid.ReplaceAllString(s, "")
-
-	// Replace `:` with more CW friendly ` =`
-	s = strings.ReplaceAll(s, ":", " =")
-
-	fmt.Println(s)
-	cw.String(s)
-	cw.String(" = ")
-	cw.Sync()
-}
-
-func run() error {
+func run() (err error) {
 	if url == "" {
 		return fmt.Errorf("need --url parameter to fetch from")
 	}
 
+	n, err := textflags.NewNormaliser()
+	if err != nil {
+		return err
+	}
+
 	fp := gofeed.NewParser()
 	log.Printf("Fetching RSS from %q", url)
 	feed, err := fp.ParseURL(url)
@@ -110,19 +124,42 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("failed to make cw player: %w", err)
 	}
-
-	play(cw, feed.Title)
+	// Always close the player so outputs release the radio and
+	// temporary files are tidied up
+	defer func() {
+		err = errors.Join(err, cw.Close())
+		cwflags.LogUnknowns(opt)
+	}()
+
+	textflags.Item(cw, 0, feed.Title)
+	err = textflags.Play(opt, cw, n, feed.Title)
+	if err != nil {
+		return err
+	}
 	if description {
-		play(cw, feed.Description)
+		err = textflags.Play(opt, cw, n, feed.Description)
+		if err != nil {
+			return err
+		}
 	}
 
 	for i, item := range feed.Items {
-		play(cw, fmt.Sprintf("NR %d", i+1))
-		play(cw, item.Title)
+		textflags.Item(cw, i+1, item.Title)
+		// The item number isn't normalised so it is never cut
+		err = textflags.Play(opt, cw, cwtext.New(), fmt.Sprintf("NR %d", i+1))
+		if err != nil {
+			return err
+		}
+		err = textflags.Play(opt, cw, n, item.Title)
+		if err != nil {
+			return err
+		}
 		if description {
-			play(cw, item.Description)
+			err = textflags.Play(opt, cw, n, item.Description)
+			if err != nil {
+				return err
+			}
 		}
 	}
-
-	return cw.Close()
+	return nil
 }
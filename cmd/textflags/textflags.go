@@ -0,0 +1,94 @@
+// Package textflags configures text normalisation from the flags
+package textflags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ncw/cwtool/cmd/cwflags"
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+	"github.com/ncw/cwtool/cwtext"
+	"github.com/spf13/pflag"
+)
+
+var (
+	normalise     []string
+	cutNumbers    string
+	abbreviate    bool
+	abbreviations string
+)
+
+// Add the text normalisation flags to the flagset passed in
+func Add(flags *pflag.FlagSet) {
+	AddSingle(flags)
+	flags.BoolVarP(&abbreviate, "abbreviate", "", false, "If set replace common words and phrases with CW abbreviations")
+	flags.StringVarP(&abbreviations, "abbreviations", "", "", "File of extra abbreviations for --abbreviate, one \"phrase = ABBR\" per line")
+}
+
+// AddSingle adds only the text normalisation flags which work on a
+// character at a time, for commands which normalise each key press
+func AddSingle(flags *pflag.FlagSet) {
+	var help strings.Builder
+	help.WriteString("Normalisation steps to apply to text in order, or none. Steps are:")
+	for _, info := range cwtext.Steps {
+		fmt.Fprintf(&help, "\n%s - %s", info.Name, info.Help)
+	}
+	flags.StringSliceVarP(&normalise, "normalise", "", cwtext.DefaultSteps, help.String())
+	flags.StringVarP(&cutNumbers, "cut-numbers", "", "", "Send these digits as cut numbers, eg 09 or all")
+}
+
+// NewNormaliser creates a new text normaliser from the command line flags
+func NewNormaliser() (*cwtext.Normaliser, error) {
+	n, err := cwtext.Parse(normalise)
+	if err != nil {
+		return nil, err
+	}
+	// Digits are cut before abbreviating so the digits in
+	// abbreviations like 73 are sent as they are
+	if cutNumbers != "" {
+		digits, err := cwtext.ParseCutDigits(cutNumbers)
+		if err != nil {
+			return nil, err
+		}
+		n.Add(cwtext.Cut(digits))
+	}
+	if abbreviate {
+		a := cwtext.NewAbbreviator(cwtext.Abbreviations)
+		if abbreviations != "" {
+			err = a.Load(abbreviations)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// This is a single pass which doesn't look at the
+		// replacements again so an abbreviation is never
+		// abbreviated itself
+		n.Add(a.Abbreviate)
+	}
+	return n, nil
+}
+
+// Play normalises s, prints it and plays it followed by a BT
+func Play(opt *cw.Options, cw cw.CW, n *cwtext.Normaliser, s string) error {
+	s = n.Normalise(s)
+	if s == "" {
+		return nil
+	}
+	err := cwgenerator.Check(opt, s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cwflags.Stdout(opt), s)
+	cw.String(s)
+	cw.String(" = ")
+	return cw.Sync()
+}
+
+// Item marks the start of item number called title if player can use it,
+// eg to split the output into a file per item
+func Item(player cw.CW, number int, title string) {
+	if itemiser, ok := player.(cw.Itemiser); ok {
+		itemiser.Item(number, title)
+	}
+}
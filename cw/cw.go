@@ -1,6 +1,8 @@
 // Package cw describes the implementation of CW generators and players
 package cw
 
+import "time"
+
 // CW is an interface to cover several implementations
 type CW interface {
 	// Rune adds r to the output
@@ -9,24 +11,74 @@ type CW interface {
 	// String adds s to the output
 	String(s string)
 
-	// Sync by waiting for all the Morse to be played
-	Sync()
+	// Sync by waiting for all the Morse to be played, returning
+	// any error writing it
+	Sync() error
 
 	// Close the file
 	Close() error
 }
 
+// Silencer is implemented by CWs which can add silence to the output
+type Silencer interface {
+	// Silence adds d of silence to the output
+	Silence(d time.Duration)
+}
+
+// Live is implemented by CWs which play the Morse in real time
+type Live interface {
+	// Done returns a channel which is closed when all the Morse
+	// added so far has been heard
+	Done() <-chan struct{}
+}
+
+// Itemiser is implemented by CWs which can use where each numbered
+// item of the text starts, eg to write each item to its own file
+type Itemiser interface {
+	// Item starts item number called title. The Morse added until
+	// the next call is part of it. Number 0 may be used for an
+	// introduction before the first item.
+	Item(number int, title string)
+}
+
 // Options to configure the CW generator and player
 type Options struct {
-	WPM             float64 // WPM to send Morse at
-	Farnsworth      float64 // Overall speed to send at
-	Frequency       float64 // Frequency to generate Morse at
-	SampleRate      int     // samples per second to generate
-	Channels        int
-	BitDepthInBytes int
-	MaxSampleValue  int
-	Continuous      bool   // generates CW continously, never returns EOF from Read
-	OutputFile      string // file to send output to
-	Debug           bool   // print info messages to stdout
-	Title           string // title of output to be inserted into WAV output
+	WPM                 float64 // WPM to send Morse at
+	Farnsworth          float64 // Overall speed to send at
+	Frequency           float64 // Frequency to generate Morse at
+	SampleRate          int     // samples per second to generate
+	Channels            int
+	BitDepthInBytes     int
+	MaxSampleValue      int
+	Continuous          bool          // generates CW continously, never returns EOF from Read
+	Backend             string        // audio backend to play with, eg "null" - "" for the default
+	Device              string        // audio output device to play to - "" for the default
+	Outputs             []string      // outputs to send the Morse to as passed to --out - the speaker if empty
+	OutputFile          string        // file to send output to - "-" for stdout
+	Force               bool          // overwrite existing output files
+	Speaker             bool          // play on the speaker as well as the Outputs
+	Format              string        // format of the output file - deduced from OutputFile if empty
+	Bitrate             int           // bitrate in kbit/s for compressed output formats - 0 for the default
+	OutputBits          int           // bits per sample in output files - 0 for 8*BitDepthInBytes
+	Level               float64       // peak level of the tone in dBFS - 0 for the default of about -10.5
+	Loudness            float64       // integrated loudness in LUFS to normalise output files to - 0 for none
+	Debug               bool          // print info messages to stdout
+	Title               string        // title of output to be inserted into the file metadata
+	Unknown             UnknownPolicy // what to do with runes with no Morse code
+	Unknowns            *Unknowns     // if set, keeps a tally of runes with no Morse code
+	Cues                Granularity   // which text to mark with cue points in WAV output
+	BEXT                bool          // write a Broadcast Wave bext chunk in WAV output
+	Subtitles           string        // if set write subtitles for the output file here
+	SubtitleGranularity Granularity   // how much text to put in each subtitle
+	SubtitleDelay       time.Duration // if set show subtitles this long after their Morse ends
+	Split               string        // if set split the output into files, "item" for one per item or a duration
+	Playlist            string        // playlist for split files - defaults to OutputFile with a .m3u extension
+	KeyLine             string        // serial port line to key the Morse with, "dtr" or "rts"
+	PTTLine             string        // serial port line to use for PTT - "" for none
+	PTTLead             time.Duration // time PTT is on before the Morse starts
+	PTTTail             time.Duration // time PTT stays on after the Morse ends
+	Weighting           int           // percentage weighting of the elements for keyers - 0 for the default of 50
+	Visual              bool          // show the Morse in the terminal as well
+	VisualStyle         string        // how to show the Morse in the terminal, eg "block"
+	VisualLetters       bool          // show each letter in the terminal after its Morse
 }
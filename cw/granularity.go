@@ -0,0 +1,53 @@
+package cw
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Granularity says how finely to mark the text in the output
+type Granularity int
+
+// Granularities for marking the text
+const (
+	GranularityNone Granularity = iota // don't mark the text
+	GranularityItem                    // mark each item, the text between calls to Sync
+	GranularityWord                    // mark each word
+	GranularityChar                    // mark each character
+)
+
+var granularityNames = []string{
+	GranularityNone: "none",
+	GranularityItem: "item",
+	GranularityWord: "word",
+	GranularityChar: "char",
+}
+
+// GranularityNames returns the names of all the granularities joined with sep
+func GranularityNames(sep string) string {
+	return strings.Join(granularityNames, sep)
+}
+
+// String turns the granularity into a string
+func (g Granularity) String() string {
+	if g < 0 || int(g) >= len(granularityNames) {
+		return fmt.Sprintf("Granularity(%d)", int(g))
+	}
+	return granularityNames[g]
+}
+
+// Set the granularity from a string - for pflag.Value
+func (g *Granularity) Set(s string) error {
+	for i, name := range granularityNames {
+		if strings.EqualFold(s, name) {
+			*g = Granularity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown granularity %q: must be one of %s", s, GranularityNames(", "))
+}
+
+// Type of the value - for pflag.Value
+func (g *Granularity) Type() string {
+	return "granularity"
+}
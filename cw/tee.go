@@ -0,0 +1,126 @@
+package cw
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Tee sends the Morse to several CWs at once, eg to the speaker and a
+// file.
+//
+// If one of them is Live then the others follow its timeline - when
+// Morse is added after it has gone quiet, the same length of silence
+// is added to the others first if they are Silencers. This means a
+// recording of an interactive session has the pauses in it which were
+// heard.
+type Tee struct {
+	sinks  []CW
+	live   Live // first of sinks which is Live if any
+	mu     sync.Mutex
+	done   <-chan struct{} // Done channel of live being watched
+	idleAt time.Time       // time live went quiet if done is closed
+}
+
+// NewTee makes a Tee sending the Morse to sinks
+func NewTee(sinks ...CW) *Tee {
+	t := &Tee{
+		sinks: sinks,
+	}
+	for _, sink := range sinks {
+		if live, ok := sink.(Live); ok {
+			t.live = live
+			break
+		}
+	}
+	return t
+}
+
+// Add silence to the sinks which aren't live for any time live has
+// been quiet, then call fn for each sink and watch live to see when
+// it goes quiet again
+func (t *Tee) add(fn func(sink CW)) {
+	if t.live == nil {
+		for _, sink := range t.sinks {
+			fn(sink)
+		}
+		return
+	}
+	t.mu.Lock()
+	var gap time.Duration
+	if !t.idleAt.IsZero() {
+		gap = time.Since(t.idleAt)
+		t.idleAt = time.Time{}
+	}
+	t.mu.Unlock()
+	for _, sink := range t.sinks {
+		if live, _ := sink.(Live); live != t.live && gap > 0 {
+			if s, ok := sink.(Silencer); ok {
+				s.Silence(gap)
+			}
+		}
+		fn(sink)
+	}
+	done := t.live.Done()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if done == t.done {
+		return
+	}
+	t.done = done
+	go func() {
+		<-done
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.done == done {
+			t.idleAt = time.Now()
+		}
+	}()
+}
+
+// Rune adds r to the output
+func (t *Tee) Rune(r rune) {
+	t.add(func(sink CW) {
+		sink.Rune(r)
+	})
+}
+
+// String adds s to the output
+func (t *Tee) String(s string) {
+	t.add(func(sink CW) {
+		sink.String(s)
+	})
+}
+
+// Item starts a new item in the outputs which are Itemisers
+func (t *Tee) Item(number int, title string) {
+	for _, sink := range t.sinks {
+		if i, ok := sink.(Itemiser); ok {
+			i.Item(number, title)
+		}
+	}
+}
+
+// Sync all the outputs
+func (t *Tee) Sync() error {
+	var errs []error
+	for _, sink := range t.sinks {
+		errs = append(errs, sink.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close all the outputs
+func (t *Tee) Close() error {
+	var errs []error
+	for _, sink := range t.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Check interfaces
+var (
+	_ CW       = (*Tee)(nil)
+	_ Itemiser = (*Tee)(nil)
+)
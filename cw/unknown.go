@@ -0,0 +1,135 @@
+package cw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// UnknownPolicy says what to do with runes which have no Morse code
+type UnknownPolicy int
+
+// Policies for runes with no Morse code
+const (
+	UnknownDrop          UnknownPolicy = iota // leave the rune out
+	UnknownError                              // refuse to send the text
+	UnknownHH                                 // send the error prosign HH instead
+	UnknownQuestion                           // send ? instead
+	UnknownTransliterate                      // send the nearest equivalent, eg é as E
+)
+
+var unknownPolicyNames = []string{
+	UnknownDrop:          "drop",
+	UnknownError:         "error",
+	UnknownHH:            "hh",
+	UnknownQuestion:      "question",
+	UnknownTransliterate: "transliterate",
+}
+
+// UnknownPolicyNames returns the names of all the policies joined with sep
+func UnknownPolicyNames(sep string) string {
+	return strings.Join(unknownPolicyNames, sep)
+}
+
+// String turns the policy into a string
+func (p UnknownPolicy) String() string {
+	if p < 0 || int(p) >= len(unknownPolicyNames) {
+		return fmt.Sprintf("UnknownPolicy(%d)", int(p))
+	}
+	return unknownPolicyNames[p]
+}
+
+// Set the policy from a string - for pflag.Value
+func (p *UnknownPolicy) Set(s string) error {
+	for i, name := range unknownPolicyNames {
+		if strings.EqualFold(s, name) {
+			*p = UnknownPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown policy %q: must be one of %s", s, UnknownPolicyNames(", "))
+}
+
+// Type of the value - for pflag.Value
+func (p *UnknownPolicy) Type() string {
+	return "policy"
+}
+
+// Unknowns keeps a tally of the runes which had no Morse code and
+// what was sent instead.
+//
+// It is safe to call the methods on a nil *Unknowns in which case
+// nothing is recorded.
+type Unknowns struct {
+	mu          sync.Mutex
+	dropped     map[rune]int
+	substituted map[rune]int
+	substitutes map[rune]string
+}
+
+// Drop records that r was left out
+func (u *Unknowns) Drop(r rune) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.dropped == nil {
+		u.dropped = map[rune]int{}
+	}
+	u.dropped[r]++
+}
+
+// Substitute records that r was sent as s
+func (u *Unknowns) Substitute(r rune, s string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.substituted == nil {
+		u.substituted = map[rune]int{}
+		u.substitutes = map[rune]string{}
+	}
+	u.substituted[r]++
+	u.substitutes[r] = s
+}
+
+// sortedRunes returns the keys of counts in order
+func sortedRunes(counts map[rune]int) []rune {
+	rs := make([]rune, 0, len(counts))
+	for r := range counts {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i] < rs[j]
+	})
+	return rs
+}
+
+// Summary describes what was dropped or substituted, or returns ""
+// if nothing was
+func (u *Unknowns) Summary() string {
+	if u == nil {
+		return ""
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var out strings.Builder
+	if len(u.dropped) > 0 {
+		out.WriteString("Dropped characters with no Morse code:")
+		for _, r := range sortedRunes(u.dropped) {
+			fmt.Fprintf(&out, " %q x%d", r, u.dropped[r])
+		}
+		out.WriteString("\n")
+	}
+	if len(u.substituted) > 0 {
+		out.WriteString("Substituted characters with no Morse code:")
+		for _, r := range sortedRunes(u.substituted) {
+			fmt.Fprintf(&out, " %q->%q x%d", r, u.substitutes[r], u.substituted[r])
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
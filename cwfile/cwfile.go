@@ -1,3 +1,4 @@
+// Package cwfile writes Morse code to audio files
 package cwfile
 
 import (
@@ -6,11 +7,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/go-audio/audio"
-	"github.com/go-audio/wav"
 	"github.com/ncw/cwtool/cw"
 	"github.com/ncw/cwtool/cwgenerator"
 )
@@ -19,37 +20,91 @@ import (
 type Player struct {
 	generator *cwgenerator.Generator
 	opt       *cw.Options
-	out       io.WriteCloser
-	encoder   *wav.Encoder
-	buf       []byte          // raw data buffer
-	abuf      []int           // int sample buffer
-	aibuf     audio.IntBuffer // buffer to send to output
+	out       output
+	subOut    *atomicFile // subtitles output if required
+	encoder   Encoder
+	buf       []byte // raw data buffer
+	abuf      []int  // int sample buffer
+	md        *Metadata
+	labels    []label // labels for the text so far if required
+	subtitles []label // labels for the subtitles so far if required
+	written   int     // samples per channel written so far
+	err       error   // first error writing the output
+	quantiser *quantiser
+	meter     *loudnessMeter // set if normalising the loudness
+	pending   []int16        // samples waiting for the loudness to be measured
 }
 
 func New(opt *cw.Options) (*Player, error) {
+	format, err := Format(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	info := lookup(format)
+
+	// Adjust the sample rate if the format doesn't support it,
+	// working on a copy of the options so the caller's are unchanged
+	newOpt := *opt
+	opt = &newOpt
+	if sampleRate := info.sampleRate(opt.SampleRate); sampleRate != opt.SampleRate {
+		log.Printf("%s doesn't support %d Hz so using %d Hz", format, opt.SampleRate, sampleRate)
+		opt.SampleRate = sampleRate
+	}
+
 	generator := cwgenerator.New(opt)
 
+	// The encoder gets the output bit depth but the generator
+	// always makes samples of BitDepthInBytes
+	bits := opt.OutputBits
+	if bits == 0 {
+		bits = 8 * opt.BitDepthInBytes
+	}
+	if bits != 8 && bits != 16 && bits != 24 && bits != 32 {
+		return nil, fmt.Errorf("can't write %d bits per sample: must be 8, 16, 24 or 32", bits)
+	}
+	// Adjust the bits if the format doesn't support them, only
+	// logging if they were asked for
+	if supported := info.bits(bits); supported != bits {
+		if opt.OutputBits != 0 {
+			log.Printf("%s doesn't support %d bits per sample so using %d", format, bits, supported)
+		}
+		bits = supported
+	}
+	encoderOpt := *opt
+	encoderOpt.BitDepthInBytes = bits / 8
+
+	if opt.Subtitles != "" {
+		_, err = subtitleFormat(opt.Subtitles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Destination file
-	out, err := os.Create(opt.OutputFile)
+	out, err := createOutput(opt.OutputFile, opt.Force)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create wav output file: %w", err)
+		return nil, fmt.Errorf("couldn't create %s output file: %w", format, err)
+	}
+
+	// The subtitles are created alongside so they are checked before
+	// anything is written and committed with the audio
+	var subOut *atomicFile
+	if opt.Subtitles != "" {
+		subOut, err = createFile(opt.Subtitles, opt.Force)
+		if err != nil {
+			_ = out.Abort()
+			return nil, fmt.Errorf("couldn't create subtitle file: %w", err)
+		}
 	}
 
-	// setup the encoder
-	encoder := wav.NewEncoder(out,
-		opt.SampleRate,
-		8*opt.BitDepthInBytes,
-		opt.Channels,
-		1, // PCM format
-	)
 	software := strings.Join(os.Args, " ")
 	title := opt.Title
 	if title == "" {
 		title = software
 	}
-	encoder.Metadata = &wav.Metadata{
+	md := &Metadata{
 		Title:    title,
-		Product:  title,
 		Software: software,
 		Artist:   "cwtool",
 	}
@@ -58,21 +113,40 @@ func New(opt *cw.Options) (*Player, error) {
 		generator: generator,
 		opt:       opt,
 		out:       out,
-		encoder:   encoder,
+		subOut:    subOut,
+		md:        md,
+		quantiser: newQuantiser(opt.MaxSampleValue, bits),
+	}
+	if opt.Loudness != 0 {
+		p.meter = newLoudnessMeter(opt.SampleRate, opt.Channels, opt.MaxSampleValue)
+	}
+
+	// setup the encoder
+	encoder, err := info.New(out, &encoderOpt, md)
+	if err != nil {
+		_ = p.abort()
+		return nil, err
+	}
+	p.encoder = encoder
+
+	if opt.Cues != cw.GranularityNone || opt.BEXT {
+		if _, ok := encoder.(labeller); !ok {
+			_ = p.abort()
+			return nil, fmt.Errorf("%s output can't store cue points or a bext chunk - use a .wav file", format)
+		}
+		generator.TrackMarks()
+	}
+	if opt.Subtitles != "" {
+		generator.TrackMarks()
+	}
+	if k, ok := encoder.(Keyer); ok {
+		generator.SetKeying(k.Key)
 	}
 
 	// Create buffers for writing to file
 	const bufSize = 64 * 1024
 	p.buf = make([]byte, p.opt.BitDepthInBytes*bufSize)
 	p.abuf = make([]int, bufSize)
-	p.aibuf = audio.IntBuffer{
-		Format: &audio.Format{
-			NumChannels: p.opt.Channels,
-			SampleRate:  p.opt.SampleRate,
-		},
-		Data:           p.abuf[:0],
-		SourceBitDepth: 8 * p.opt.BitDepthInBytes,
-	}
 
 	return p, nil
 }
@@ -87,43 +161,149 @@ func (p *Player) String(s string) {
 	p.generator.String(s)
 }
 
-// Sync the Morse so far to the file
-func (p *Player) Sync() {
+// Silence adds d of silence to the output
+func (p *Player) Silence(d time.Duration) {
+	p.generator.Silence(d)
+}
+
+// Sync the Morse so far to the file.
+//
+// Once this has returned an error all further calls will return it.
+func (p *Player) Sync() error {
+	if p.err != nil {
+		return p.err
+	}
 	for {
 		n, err := p.generator.Read(p.buf)
 		if err != io.EOF && err != nil {
-			log.Printf("read audio failed: %v", err)
-			break
+			p.err = fmt.Errorf("read audio failed: %w", err)
+			return p.err
 		}
-		isEOF := err == io.EOF
+		// The generator never returns EOF in continuous mode
+		isEOF := err == io.EOF || n == 0
 		// Convert into ints for encoding
 		samples := n / p.opt.BitDepthInBytes
 		for i := 0; i < samples; i++ {
 			// FIXME assumes signed 16 bit
 			p.abuf[i] = int(int16(binary.LittleEndian.Uint16(p.buf[2*i : 2*i+2])))
 		}
-		p.aibuf.Data = p.abuf[:samples]
-
-		err = p.encoder.Write(&p.aibuf)
-		if err != nil {
-			log.Printf("write audio failed: %v", err)
-			break
+		if p.meter != nil {
+			// Keep the samples until the loudness is known
+			p.meter.add(p.abuf[:samples])
+			for _, sample := range p.abuf[:samples] {
+				p.pending = append(p.pending, int16(sample))
+			}
+		} else {
+			err = p.write(p.abuf[:samples])
+			if err != nil {
+				return err
+			}
 		}
+		p.written += samples / p.opt.Channels
 
 		if isEOF {
 			break
 		}
 	}
+	marks := p.generator.Marks()
+	p.labels = append(p.labels, makeLabels(marks, p.opt.Cues)...)
+	if p.opt.Subtitles != "" {
+		p.subtitles = append(p.subtitles, makeLabels(marks, p.opt.SubtitleGranularity)...)
+	}
+	return nil
+}
+
+// Write samples to the encoder at the output bit depth
+func (p *Player) write(samples []int) error {
+	p.quantiser.quantise(samples)
+	err := p.encoder.Write(samples)
+	if err != nil {
+		p.err = fmt.Errorf("write audio failed: %w", err)
+		return p.err
+	}
+	return nil
+}
+
+// Normalise the pending samples to the target loudness and write them
+func (p *Player) normalise() error {
+	gain, limited := p.meter.normalise(p.opt.Loudness)
+	if limited {
+		log.Printf("Can't reach %.1f LUFS without the peaks going over %.1f dBFS so using %.1f LUFS", p.opt.Loudness, loudnessMaxPeak, p.meter.integrated()+20*math.Log10(gain))
+	} else if p.opt.Debug {
+		log.Printf("Measured %.1f LUFS so applying %+.1f dB of gain", p.meter.integrated(), 20*math.Log10(gain))
+	}
+	p.quantiser.setGain(p.quantiser.gain * gain)
+	for len(p.pending) > 0 {
+		samples := len(p.pending)
+		if samples > len(p.abuf) {
+			samples = len(p.abuf)
+		}
+		for i, sample := range p.pending[:samples] {
+			p.abuf[i] = int(sample)
+		}
+		err := p.write(p.abuf[:samples])
+		if err != nil {
+			return err
+		}
+		p.pending = p.pending[samples:]
+	}
+	return nil
+}
+
+// Duration returns the length of the audio written so far
+func (p *Player) Duration() time.Duration {
+	return time.Duration(p.written) * time.Second / time.Duration(p.opt.SampleRate)
 }
 
-// Close the output
+// Close the output.
+//
+// The file only appears at OutputFile if everything was written
+// successfully.
 func (p *Player) Close() error {
-	p.Sync()
-	return errors.Join(
-		p.encoder.Close(),
-		p.out.Close(),
-	)
+	err := p.Sync()
+	if err == nil && p.meter != nil {
+		err = p.normalise()
+	}
+	if err == nil {
+		if l, ok := p.encoder.(labeller); ok {
+			l.Label(p.labels)
+		}
+		err = p.encoder.Close()
+	}
+	if err == nil && p.subOut != nil {
+		err = writeSubtitles(p.subOut, p.opt.Subtitles, p.subtitles, p.opt, p.md)
+	}
+	if err != nil {
+		return errors.Join(err, p.abort())
+	}
+	err = p.out.Commit()
+	if err != nil {
+		err = fmt.Errorf("failed to finish %q: %w", p.opt.OutputFile, err)
+		if p.subOut != nil {
+			err = errors.Join(err, p.subOut.Abort())
+		}
+		return err
+	}
+	if p.subOut != nil {
+		err = p.subOut.Commit()
+		if err != nil {
+			return fmt.Errorf("failed to finish %q: %w", p.opt.Subtitles, err)
+		}
+	}
+	return nil
 }
 
-// Check interface
-var _ cw.CW = (*Player)(nil)
+// abort the outputs removing anything partially written
+func (p *Player) abort() error {
+	err := p.out.Abort()
+	if p.subOut != nil {
+		err = errors.Join(err, p.subOut.Abort())
+	}
+	return err
+}
+
+// Check interfaces
+var (
+	_ cw.CW       = (*Player)(nil)
+	_ cw.Silencer = (*Player)(nil)
+)
@@ -0,0 +1,120 @@
+package cwfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// testOptions returns options for writing file
+func testOptions(file string) *cw.Options {
+	return &cw.Options{
+		WPM:             25,
+		Frequency:       600,
+		SampleRate:      8000,
+		Channels:        1,
+		BitDepthInBytes: 2,
+		MaxSampleValue:  32767,
+		Level:           -10,
+		OutputFile:      file,
+	}
+}
+
+func TestSubtitlesWithAudio(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "test.wav")
+	subtitles := filepath.Join(dir, "test.srt")
+	opt := testOptions(audio)
+	opt.Subtitles = subtitles
+	opt.SubtitleGranularity = cw.GranularityItem
+
+	// An existing subtitle file stops anything being written
+	err := os.WriteFile(subtitles, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(opt)
+	if err == nil {
+		t.Fatal("expected an error with an existing subtitle file")
+	}
+	if _, err := os.Stat(audio); !os.IsNotExist(err) {
+		t.Errorf("audio file written when the subtitles failed: %v", err)
+	}
+
+	// With --force both are written together
+	opt.Force = true
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.String("CQ")
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{audio, subtitles} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() <= 3 {
+			t.Errorf("%q too small: %d bytes", file, fi.Size())
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected only the audio and subtitles, got %d files", len(entries))
+	}
+}
+
+func TestCommitDoesNotClobber(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.txt")
+	f, err := createFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Something else makes the file while it is being written
+	err = os.WriteFile(path, []byte("theirs"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("ours")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Commit()
+	if err == nil {
+		t.Fatal("expected an error committing over a new file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "theirs" {
+		t.Errorf("file overwritten with %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %d files", len(entries))
+	}
+}
+
+func TestRemoveTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := New(testOptions(filepath.Join(dir, "test.wav")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected a temporary file, got %d files", len(entries))
+	}
+	RemoveTemporaryFiles()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("temporary file left behind: %d files", len(entries))
+	}
+}
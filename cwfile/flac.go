@@ -0,0 +1,511 @@
+package cwfile
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// FLAC encoding
+//
+// This is a minimal FLAC encoder which uses the fixed linear
+// predictors with Rice coded residuals. Morse code is mostly
+// silence, which is encoded as constant subframes, and pure tones,
+// which predict well, so this compresses nicely without needing the
+// full LPC machinery of a general purpose encoder.
+//
+// The encoder in the version of github.com/mewkiz/flac used for
+// reading FLAC only writes constant and verbatim subframes so its
+// files are as big as WAV files, and the releases which add
+// prediction pull in more dependencies and a newer Go.
+//
+// Samples are limited to 24 bits so the residuals of the fixed
+// predictors fit in an int32.
+//
+// See https://xiph.org/flac/format.html for the format.
+
+const (
+	flacBlockSize         = 4096 // samples per channel in each frame
+	flacMaxFixedOrder     = 4    // maximum order of the fixed predictors
+	flacMaxRiceParameter  = 14   // 15 is the escape code
+	flacMaxPartitionOrder = 8    // maximum log2 of the number of Rice partitions
+	flacStreamInfoLength  = 34   // bytes in the STREAMINFO block
+	flacBlockTypeInfo     = 0
+	flacBlockTypeComments = 4
+	flacVendor            = "cwtool"
+)
+
+// Codes for the common sample rates in frame headers
+var flacSampleRateCodes = map[int]uint64{
+	88200:  0x1,
+	176400: 0x2,
+	192000: 0x3,
+	8000:   0x4,
+	16000:  0x5,
+	22050:  0x6,
+	24000:  0x7,
+	32000:  0x8,
+	44100:  0x9,
+	48000:  0xA,
+	96000:  0xB,
+}
+
+// Codes for the sample sizes in frame headers
+var flacSampleSizeCodes = map[int]uint64{
+	8:  0x1,
+	12: 0x2,
+	16: 0x4,
+	20: 0x5,
+	24: 0x6,
+}
+
+// flacEncoder writes FLAC files
+type flacEncoder struct {
+	out          io.Writer
+	channels     int
+	bps          int // bits per sample
+	sampleRate   int
+	block        [][]int32 // samples for each channel in the current block
+	frameNumber  uint64
+	totalSamples uint64 // samples per channel written
+	minFrameSize int
+	maxFrameSize int
+	md5          hash.Hash
+	md5buf       []byte
+	bw           bitWriter
+	residuals    []int32
+}
+
+func newFLAC(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error) {
+	e := &flacEncoder{
+		out:        out,
+		channels:   opt.Channels,
+		bps:        8 * opt.BitDepthInBytes,
+		sampleRate: opt.SampleRate,
+		block:      make([][]int32, opt.Channels),
+		md5:        md5.New(),
+		residuals:  make([]int32, flacBlockSize),
+	}
+	if e.channels < 1 || e.channels > 8 {
+		return nil, fmt.Errorf("flac: can't encode %d channels", e.channels)
+	}
+	if e.bps < 4 || e.bps > 24 {
+		return nil, fmt.Errorf("flac: can't encode %d bits per sample", e.bps)
+	}
+	for i := range e.block {
+		e.block[i] = make([]int32, 0, flacBlockSize)
+	}
+
+	// Write the stream marker and metadata
+	e.bw.writeBytes([]byte("fLaC"))
+	e.bw.writeBits(flacBlockTypeInfo, 8)
+	e.bw.writeBits(flacStreamInfoLength, 24)
+	// The MD5 is left as zeros, meaning unknown, unless the
+	// STREAMINFO can be rewritten by Close
+	e.bw.writeBytes(e.streamInfo(make([]byte, md5.Size)))
+	comments := flacComments(map[string]string{
+		"TITLE":   md.Title,
+		"ALBUM":   md.Title,
+		"ARTIST":  md.Artist,
+		"ENCODER": md.Software,
+	})
+	e.bw.writeBits(1<<7|flacBlockTypeComments, 8) // last metadata block
+	e.bw.writeBits(uint64(len(comments)), 24)
+	e.bw.writeBytes(comments)
+	_, err := out.Write(e.bw.buf)
+	e.bw.reset()
+	if err != nil {
+		return nil, fmt.Errorf("flac: failed to write header: %w", err)
+	}
+	return e, nil
+}
+
+// Make the body of the STREAMINFO block with the MD5 of the samples
+func (e *flacEncoder) streamInfo(md5sum []byte) []byte {
+	var bw bitWriter
+	bw.writeBits(flacBlockSize, 16) // minimum block size
+	bw.writeBits(flacBlockSize, 16) // maximum block size
+	bw.writeBits(uint64(e.minFrameSize), 24)
+	bw.writeBits(uint64(e.maxFrameSize), 24)
+	bw.writeBits(uint64(e.sampleRate), 20)
+	bw.writeBits(uint64(e.channels-1), 3)
+	bw.writeBits(uint64(e.bps-1), 5)
+	bw.writeBits(e.totalSamples, 36)
+	bw.writeBytes(md5sum)
+	return bw.buf
+}
+
+// Make a VORBIS_COMMENT block body from the tags, leaving out empty ones
+func flacComments(tags map[string]string) []byte {
+	var comments []string
+	for _, name := range []string{"TITLE", "ALBUM", "ARTIST", "ENCODER"} {
+		if value := tags[name]; value != "" {
+			comments = append(comments, name+"="+value)
+		}
+	}
+	// Vorbis comments use little endian lengths
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(flacVendor)))
+	buf = append(buf, flacVendor...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(comments)))
+	for _, comment := range comments {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(comment)))
+		buf = append(buf, comment...)
+	}
+	return buf
+}
+
+// Write the interleaved samples
+func (e *flacEncoder) Write(samples []int) error {
+	bytesPerSample := (e.bps + 7) / 8
+	for i, sample := range samples {
+		ch := i % e.channels
+		e.block[ch] = append(e.block[ch], int32(sample))
+		// The MD5 is of the little endian interleaved samples
+		e.md5buf = e.md5buf[:0]
+		for b := 0; b < bytesPerSample; b++ {
+			e.md5buf = append(e.md5buf, byte(sample>>(8*b)))
+		}
+		_, _ = e.md5.Write(e.md5buf)
+		if ch == e.channels-1 && len(e.block[ch]) == flacBlockSize {
+			err := e.writeFrame()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write the current block as a frame
+func (e *flacEncoder) writeFrame() error {
+	blockSize := len(e.block[e.channels-1])
+	if blockSize == 0 {
+		return nil
+	}
+	bw := &e.bw
+	bw.reset()
+
+	// Frame header
+	bw.writeBits(0xFFF8, 16) // sync code, fixed block size
+	bw.writeBits(0x7, 4)     // block size in 16 bits at end of header
+	sampleRateCode, found := flacSampleRateCodes[e.sampleRate]
+	if !found {
+		if e.sampleRate < 1<<16 {
+			sampleRateCode = 0xD // sample rate in Hz in 16 bits at end of header
+		} else {
+			sampleRateCode = 0x0 // sample rate from STREAMINFO
+		}
+	}
+	bw.writeBits(sampleRateCode, 4)
+	bw.writeBits(uint64(e.channels-1), 4)       // independent channels
+	bw.writeBits(flacSampleSizeCodes[e.bps], 3) // 0 means from STREAMINFO
+	bw.writeBits(0, 1)                          // reserved
+	bw.writeUTF8(e.frameNumber)
+	bw.writeBits(uint64(blockSize-1), 16)
+	if sampleRateCode == 0xD {
+		bw.writeBits(uint64(e.sampleRate), 16)
+	}
+	bw.writeBits(uint64(crc8(bw.buf)), 8)
+
+	for ch := range e.block {
+		e.writeSubframe(e.block[ch][:blockSize])
+		e.block[ch] = e.block[ch][:0]
+	}
+
+	// Frame footer
+	bw.align()
+	bw.writeBits(uint64(crc16(bw.buf)), 16)
+
+	frameSize := len(bw.buf)
+	if e.minFrameSize == 0 || frameSize < e.minFrameSize {
+		e.minFrameSize = frameSize
+	}
+	if frameSize > e.maxFrameSize {
+		e.maxFrameSize = frameSize
+	}
+	e.frameNumber++
+	e.totalSamples += uint64(blockSize)
+	_, err := e.out.Write(bw.buf)
+	if err != nil {
+		return fmt.Errorf("flac: failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// Compute the residuals of samples using the fixed predictor of order
+func fixedResiduals(residuals []int32, samples []int32, order int) []int32 {
+	residuals = residuals[:0]
+	for i := order; i < len(samples); i++ {
+		var r int32
+		switch order {
+		case 0:
+			r = samples[i]
+		case 1:
+			r = samples[i] - samples[i-1]
+		case 2:
+			r = samples[i] - 2*samples[i-1] + samples[i-2]
+		case 3:
+			r = samples[i] - 3*samples[i-1] + 3*samples[i-2] - samples[i-3]
+		case 4:
+			r = samples[i] - 4*samples[i-1] + 6*samples[i-2] - 4*samples[i-3] + samples[i-4]
+		}
+		residuals = append(residuals, r)
+	}
+	return residuals
+}
+
+// Map signed residuals onto unsigned ones for Rice coding
+func zigzag(r int32) uint32 {
+	return uint32((r << 1) ^ (r >> 31))
+}
+
+// Find the best Rice parameter for the residuals and the number of
+// bits they take to encode with it
+func riceParameter(residuals []int32) (parameter uint, bits uint64) {
+	bits = math.MaxUint64
+	for k := uint(0); k <= flacMaxRiceParameter; k++ {
+		n := uint64(len(residuals)) * uint64(k+1)
+		for _, r := range residuals {
+			n += uint64(zigzag(r) >> k)
+		}
+		if n < bits {
+			parameter, bits = k, n
+		}
+	}
+	return parameter, bits
+}
+
+// Split the residuals of a block into 2**partitionOrder partitions.
+//
+// The first partition is shorter by order as the warm up samples
+// have no residuals.
+//
+// It returns nil if the block can't be split like that.
+func ricePartitions(residuals []int32, blockSize, order, partitionOrder int) (partitions [][]int32) {
+	n := 1 << partitionOrder
+	if blockSize%n != 0 || blockSize/n <= order {
+		return nil
+	}
+	size := blockSize / n
+	start := 0
+	for i := 0; i < n; i++ {
+		end := (i+1)*size - order
+		partitions = append(partitions, residuals[start:end])
+		start = end
+	}
+	return partitions
+}
+
+// Find the best way to Rice code the residuals returning the
+// partition order and the number of bits they will take
+func riceCoding(residuals []int32, blockSize, order int) (bestPartitionOrder int, bestBits uint64) {
+	bestBits = math.MaxUint64
+	for partitionOrder := 0; partitionOrder <= flacMaxPartitionOrder; partitionOrder++ {
+		partitions := ricePartitions(residuals, blockSize, order, partitionOrder)
+		if partitions == nil {
+			break
+		}
+		bits := uint64(2 + 4) // coding method and partition order
+		for _, partition := range partitions {
+			_, partitionBits := riceParameter(partition)
+			bits += 4 + partitionBits
+		}
+		if bits < bestBits {
+			bestPartitionOrder, bestBits = partitionOrder, bits
+		}
+	}
+	return bestPartitionOrder, bestBits
+}
+
+// Write a subframe for a single channel choosing the smallest encoding
+func (e *flacEncoder) writeSubframe(samples []int32) {
+	bw := &e.bw
+	bps := uint(e.bps)
+
+	// Use a constant subframe if all the samples are the same
+	constant := true
+	for _, sample := range samples[1:] {
+		if sample != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.writeBits(0x00, 8)
+		bw.writeSigned(samples[0], bps)
+		return
+	}
+
+	// Otherwise find the best fixed predictor
+	bestOrder := -1
+	bestBits := uint64(len(samples)) * uint64(bps) // verbatim
+	bestPartitionOrder := 0
+	for order := 0; order <= flacMaxFixedOrder && order < len(samples); order++ {
+		e.residuals = fixedResiduals(e.residuals, samples, order)
+		partitionOrder, bits := riceCoding(e.residuals, len(samples), order)
+		bits += uint64(order) * uint64(bps)
+		if bits < bestBits {
+			bestOrder, bestBits, bestPartitionOrder = order, bits, partitionOrder
+		}
+	}
+
+	if bestOrder < 0 {
+		bw.writeBits(0x01<<1, 8) // verbatim
+		for _, sample := range samples {
+			bw.writeSigned(sample, bps)
+		}
+		return
+	}
+
+	bw.writeBits(uint64(0x08|bestOrder)<<1, 8) // fixed predictor
+	for _, sample := range samples[:bestOrder] {
+		bw.writeSigned(sample, bps)
+	}
+	bw.writeBits(0, 2) // Rice coding with 4 bit parameters
+	bw.writeBits(uint64(bestPartitionOrder), 4)
+	e.residuals = fixedResiduals(e.residuals, samples, bestOrder)
+	for _, partition := range ricePartitions(e.residuals, len(samples), bestOrder, bestPartitionOrder) {
+		parameter, _ := riceParameter(partition)
+		bw.writeBits(uint64(parameter), 4)
+		for _, r := range partition {
+			u := zigzag(r)
+			bw.writeUnary(u >> parameter)
+			bw.writeBits(uint64(u), parameter)
+		}
+	}
+}
+
+// Close flushes the last frame and updates the STREAMINFO if the
+// output is seekable
+func (e *flacEncoder) Close() error {
+	err := e.writeFrame()
+	if err != nil {
+		return err
+	}
+	ws, ok := e.out.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	// The STREAMINFO is after the stream marker and block header
+	_, err = ws.Seek(8, io.SeekStart)
+	if err == nil {
+		_, err = ws.Write(e.streamInfo(e.md5.Sum(nil)))
+	}
+	if err == nil {
+		_, err = ws.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return fmt.Errorf("flac: failed to update STREAMINFO: %w", err)
+	}
+	return nil
+}
+
+// bitWriter accumulates bits most significant first into buf
+type bitWriter struct {
+	buf  []byte
+	acc  uint64 // bits not yet written to buf
+	nacc uint   // number of bits in acc
+}
+
+// Reset the writer to empty
+func (bw *bitWriter) reset() {
+	bw.buf = bw.buf[:0]
+	bw.acc = 0
+	bw.nacc = 0
+}
+
+// Write the bottom bits of v - bits must be <= 56
+func (bw *bitWriter) writeBits(v uint64, bits uint) {
+	if bits == 0 {
+		return
+	}
+	bw.acc = bw.acc<<bits | v&(1<<bits-1)
+	bw.nacc += bits
+	for bw.nacc >= 8 {
+		bw.nacc -= 8
+		bw.buf = append(bw.buf, byte(bw.acc>>bw.nacc))
+	}
+}
+
+// Write a two's complement signed value in bits
+func (bw *bitWriter) writeSigned(v int32, bits uint) {
+	bw.writeBits(uint64(uint32(v)), bits)
+}
+
+// Write q in unary as q zeros followed by a one
+func (bw *bitWriter) writeUnary(q uint32) {
+	for ; q >= 32; q -= 32 {
+		bw.writeBits(0, 32)
+	}
+	bw.writeBits(1, uint(q)+1)
+}
+
+// Write bytes which must be byte aligned
+func (bw *bitWriter) writeBytes(bs []byte) {
+	for _, b := range bs {
+		bw.writeBits(uint64(b), 8)
+	}
+}
+
+// Pad with zeros to a byte boundary
+func (bw *bitWriter) align() {
+	if bw.nacc > 0 {
+		bw.writeBits(0, 8-bw.nacc)
+	}
+}
+
+// Write v in the extended UTF-8 coding FLAC uses for frame numbers
+func (bw *bitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		bw.writeBits(v, 8)
+		return
+	}
+	// Work out how many continuation bytes are needed
+	n := uint(1)
+	for v >= 1<<(5*n+6) {
+		n++
+	}
+	// The first byte has n+1 leading ones then the top bits
+	lead := uint64(0xFF<<(7-n)) & 0xFF
+	bw.writeBits(lead|v>>(6*n), 8)
+	for i := n; i > 0; i-- {
+		bw.writeBits(0x80|(v>>(6*(i-1)))&0x3F, 8)
+	}
+}
+
+// CRC-8 with polynomial x^8 + x^2 + x^1 + x^0 as used in frame headers
+func crc8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// CRC-16 with polynomial x^16 + x^15 + x^2 + x^0 as used in frame footers
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
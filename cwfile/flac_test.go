@@ -0,0 +1,205 @@
+package cwfile
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/ncw/cwtool/cw"
+)
+
+// makeTestSamples makes interleaved samples of keyed tones like Morse
+// followed by some noise, which doesn't predict well, at the full
+// scale of bits
+func makeTestSamples(n, channels, bits int) []int {
+	peak := float64(int(1)<<(bits-1) - 1)
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]int, 0, n*channels)
+	for i := 0; i < n; i++ {
+		for ch := 0; ch < channels; ch++ {
+			var v float64
+			switch {
+			case i < 2*n/3:
+				// 600 Hz at 8 kHz keyed on and off every 500 samples
+				if (i/500)%2 == 0 {
+					v = 0.7 * peak * math.Sin(2*math.Pi*600*float64(i)/8000+float64(ch))
+				}
+			default:
+				v = peak * (2*rng.Float64() - 1)
+			}
+			samples = append(samples, int(math.Round(v)))
+		}
+	}
+	return samples
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		channels int
+		bits     int
+		n        int
+		stream   bool // write to an output which can't seek
+	}{
+		{"mono16", 1, 16, 10000, false},
+		{"stereo16", 2, 16, 10000, false},
+		{"mono24", 1, 24, 10000, false},
+		{"stereo8", 2, 8, 5000, false},
+		{"short", 1, 16, 3, false},
+		{"stream", 1, 16, 10000, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opt := &cw.Options{
+				SampleRate:      8000,
+				Channels:        test.channels,
+				BitDepthInBytes: test.bits / 8,
+			}
+			samples := makeTestSamples(test.n, test.channels, test.bits)
+			path := filepath.Join(t.TempDir(), "test.flac")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out io.Writer = f
+			var buf bytes.Buffer
+			if test.stream {
+				out = &buf
+			}
+			e, err := newFLAC(out, opt, &Metadata{Title: "Test", Software: "cwtool"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Write in uneven pieces to cross the block boundaries
+			for rest := samples; len(rest) > 0; {
+				n := 1000 * test.channels
+				if n > len(rest) {
+					n = len(rest)
+				}
+				err = e.Write(rest[:n])
+				if err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			err = e.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.stream {
+				_, err = f.Write(buf.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream, err := flac.ParseFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = stream.Close()
+			}()
+			info := stream.Info
+			wantSamples := test.n
+			if test.stream {
+				wantSamples = 0 // unknown
+			}
+			if int(info.SampleRate) != opt.SampleRate || int(info.NChannels) != test.channels || int(info.BitsPerSample) != test.bits || int(info.NSamples) != wantSamples {
+				t.Fatalf("bad STREAMINFO: %+v", info)
+			}
+			var got []int
+			for {
+				frame, err := stream.ParseNext()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < int(frame.BlockSize); i++ {
+					for _, subframe := range frame.Subframes {
+						got = append(got, int(subframe.Samples[i]))
+					}
+				}
+			}
+			if len(got) != len(samples) {
+				t.Fatalf("decoded %d samples, want %d", len(got), len(samples))
+			}
+			for i := range samples {
+				if got[i] != samples[i] {
+					t.Fatalf("sample %d: got %d, want %d", i, got[i], samples[i])
+				}
+			}
+
+			// The MD5 is of the little endian interleaved samples,
+			// or zeros if the STREAMINFO couldn't be rewritten
+			md5sum := md5.New()
+			bytesPerSample := test.bits / 8
+			for _, sample := range samples {
+				for b := 0; b < bytesPerSample; b++ {
+					_, _ = md5sum.Write([]byte{byte(sample >> (8 * b))})
+				}
+			}
+			want := md5sum.Sum(nil)
+			if test.stream {
+				want = make([]byte, md5.Size)
+			}
+			if string(want) != string(info.MD5sum[:]) {
+				t.Errorf("STREAMINFO MD5 %x, want %x", info.MD5sum, want)
+			}
+		})
+	}
+}
+
+func TestFLACBits(t *testing.T) {
+	for _, test := range []struct {
+		bits int
+		want int
+	}{
+		{8, 8},
+		{16, 16},
+		{24, 24},
+		{32, 24}, // FLAC can't store 32 bits
+	} {
+		path := filepath.Join(t.TempDir(), "test.flac")
+		opt := testOptions(path)
+		opt.OutputBits = test.bits
+		p, err := New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.String("E E")
+		err = p.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := flac.ParseFile(path)
+		if err != nil {
+			t.Fatalf("%d bits: %v", test.bits, err)
+		}
+		got := int(stream.Info.BitsPerSample)
+		for {
+			_, err = stream.ParseNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%d bits: %v", test.bits, err)
+			}
+		}
+		_ = stream.Close()
+		if got != test.want {
+			t.Errorf("--bits %d: got %d bits, want %d", test.bits, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cwfile
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// label marks where some text is in the output
+type label struct {
+	Start  int // first sample in samples per channel
+	Length int // number of samples per channel
+	Text   string
+}
+
+// labeller is implemented by encoders which can store labels
+type labeller interface {
+	// Label adds labels to the output - called before Close
+	Label(labels []label)
+}
+
+// makeLabels groups the marks from the generator into labels at the
+// granularity given.
+//
+// The marks should be for a single item, the text sent between calls
+// to Sync.
+func makeLabels(marks []cwgenerator.Mark, granularity cw.Granularity) (labels []label) {
+	if granularity == cw.GranularityNone {
+		return nil
+	}
+	var (
+		text        []rune
+		first, last cwgenerator.Mark // first mark and last non space mark in text
+	)
+	flush := func() {
+		if len(text) == 0 {
+			return
+		}
+		labels = append(labels, label{
+			Start:  first.Start,
+			Length: last.Start + last.Length - first.Start,
+			Text:   strings.TrimSpace(string(text)),
+		})
+		text = text[:0]
+	}
+	for _, mark := range marks {
+		space := unicode.IsSpace(mark.Rune)
+		if space {
+			if granularity != cw.GranularityItem {
+				flush()
+				continue
+			}
+			if len(text) == 0 {
+				continue
+			}
+		}
+		if len(text) == 0 {
+			first = mark
+		}
+		text = append(text, mark.Rune)
+		if !space {
+			last = mark
+		}
+		if granularity == cw.GranularityChar {
+			flush()
+		}
+	}
+	flush()
+	return labels
+}
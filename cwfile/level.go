@@ -0,0 +1,264 @@
+package cwfile
+
+// Loudness measurement and requantisation
+//
+// The loudness meter measures the integrated loudness of the output
+// in LUFS according to ITU-R BS.1770-4 so the output can be
+// normalised to a target loudness, as used by podcast and streaming
+// platforms.
+//
+// The quantiser converts the samples from the generator into the
+// output bit depth applying any gain, with TPDF dither whenever this
+// loses resolution.
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	loudnessBlock    = 4      // gating block length in steps
+	loudnessStep     = 0.1    // step between gating blocks in seconds
+	loudnessOffset   = -0.691 // LUFS of a mean square of 1
+	loudnessAbsolute = -70.0  // absolute gate in LUFS
+	loudnessRelative = -10.0  // relative gate in LU
+	loudnessMaxPeak  = -1.0   // highest peak in dBFS after normalisation
+)
+
+// biquad is a second order IIR filter in direct form I
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// filter x returning the output
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the two stages of the BS.1770 K-weighting filter
+// for sampleRate - a high shelf modelling the head then a high pass.
+//
+// The coefficients are calculated from the filter parameters so any
+// sample rate can be used, not just the 48 kHz in the standard.
+func kWeighting(sampleRate int) [2]biquad {
+	var stages [2]biquad
+
+	// High shelf
+	const (
+		shelfF0   = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+	)
+	K := math.Tan(math.Pi * shelfF0 / float64(sampleRate))
+	Vh := math.Pow(10, shelfGain/20)
+	Vb := math.Pow(Vh, 0.4996667741545416)
+	a0 := 1 + K/shelfQ + K*K
+	stages[0] = biquad{
+		b0: (Vh + Vb*K/shelfQ + K*K) / a0,
+		b1: 2 * (K*K - Vh) / a0,
+		b2: (Vh - Vb*K/shelfQ + K*K) / a0,
+		a1: 2 * (K*K - 1) / a0,
+		a2: (1 - K/shelfQ + K*K) / a0,
+	}
+
+	// High pass
+	const (
+		passF0 = 38.13547087602444
+		passQ  = 0.5003270373238773
+	)
+	K = math.Tan(math.Pi * passF0 / float64(sampleRate))
+	a0 = 1 + K/passQ + K*K
+	stages[1] = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (K*K - 1) / a0,
+		a2: (1 - K/passQ + K*K) / a0,
+	}
+	return stages
+}
+
+// loudnessMeter measures integrated loudness
+type loudnessMeter struct {
+	channels    int
+	scale       float64     // to convert samples to the range -1..1
+	filters     [][2]biquad // K-weighting per channel
+	stepSamples int         // samples per channel in each step
+	n           int         // samples per channel in the current step
+	sum         float64     // sum of squares in the current step
+	steps       []float64   // sums of squares of the last loudnessBlock-1 steps
+	blocks      []float64   // mean square of each gating block
+	peak        int         // highest absolute sample value
+}
+
+// newLoudnessMeter makes a loudnessMeter for samples up to
+// maxSampleValue
+func newLoudnessMeter(sampleRate, channels, maxSampleValue int) *loudnessMeter {
+	m := &loudnessMeter{
+		channels:    channels,
+		scale:       1 / float64(maxSampleValue),
+		filters:     make([][2]biquad, channels),
+		stepSamples: int(math.Round(loudnessStep * float64(sampleRate))),
+	}
+	for ch := range m.filters {
+		m.filters[ch] = kWeighting(sampleRate)
+	}
+	return m
+}
+
+// add the interleaved samples to the measurement
+func (m *loudnessMeter) add(samples []int) {
+	for i, sample := range samples {
+		if sample > m.peak {
+			m.peak = sample
+		} else if -sample > m.peak {
+			m.peak = -sample
+		}
+		f := &m.filters[i%m.channels]
+		x := f[1].filter(f[0].filter(float64(sample) * m.scale))
+		m.sum += x * x
+		if i%m.channels == m.channels-1 {
+			m.n++
+			if m.n >= m.stepSamples {
+				m.endStep()
+			}
+		}
+	}
+}
+
+// endStep finishes the current step, adding a gating block if there
+// are enough steps
+func (m *loudnessMeter) endStep() {
+	m.steps = append(m.steps, m.sum)
+	if len(m.steps) >= loudnessBlock {
+		total := 0.0
+		for _, sum := range m.steps {
+			total += sum
+		}
+		m.blocks = append(m.blocks, total/float64(loudnessBlock*m.stepSamples))
+		m.steps = m.steps[1:]
+	}
+	m.n = 0
+	m.sum = 0
+}
+
+// loudness converts a mean square into LUFS
+func loudness(meanSquare float64) float64 {
+	return loudnessOffset + 10*math.Log10(meanSquare)
+}
+
+// gatedMean returns the mean of the blocks louder than threshold LUFS
+// and how many there were
+func gatedMean(blocks []float64, threshold float64) (mean float64, n int) {
+	for _, block := range blocks {
+		if loudness(block) > threshold {
+			mean += block
+			n++
+		}
+	}
+	if n > 0 {
+		mean /= float64(n)
+	}
+	return mean, n
+}
+
+// integrated returns the integrated loudness of the samples so far in
+// LUFS, or -Inf if they are silent
+func (m *loudnessMeter) integrated() float64 {
+	blocks := m.blocks
+	if len(blocks) == 0 {
+		// Too short for a whole block so measure what there is
+		total, n := m.sum, m.n
+		for _, sum := range m.steps {
+			total += sum
+			n += m.stepSamples
+		}
+		if n == 0 {
+			return math.Inf(-1)
+		}
+		blocks = []float64{total / float64(n)}
+	}
+	mean, n := gatedMean(blocks, loudnessAbsolute)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	mean, n = gatedMean(blocks, loudness(mean)+loudnessRelative)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	return loudness(mean)
+}
+
+// normalise returns the gain needed to bring the samples so far to
+// the target loudness in LUFS.
+//
+// The gain is limited so the peaks stay below loudnessMaxPeak and
+// limited is set if this happened.
+func (m *loudnessMeter) normalise(target float64) (gain float64, limited bool) {
+	measured := m.integrated()
+	if math.IsInf(measured, -1) || m.peak == 0 {
+		return 1, false
+	}
+	gain = math.Pow(10, (target-measured)/20)
+	maxGain := math.Pow(10, loudnessMaxPeak/20) / (float64(m.peak) * m.scale)
+	if gain > maxGain {
+		return maxGain, true
+	}
+	return gain, false
+}
+
+// quantiser converts samples to the output bit depth
+type quantiser struct {
+	gain     float64 // multiply the samples by this
+	min, max int     // range of the output samples
+	dither   bool    // add TPDF dither
+	rand     *rand.Rand
+}
+
+// newQuantiser makes a quantiser scaling samples up to
+// maxSampleValue into bits per sample.
+func newQuantiser(maxSampleValue, bits int) *quantiser {
+	max := 1<<(bits-1) - 1
+	q := &quantiser{
+		min: -max - 1,
+		max: max,
+		// Seeded so the output is reproducible
+		rand: rand.New(rand.NewSource(1)),
+	}
+	q.setGain(float64(max+1) / float64(maxSampleValue+1))
+	return q
+}
+
+// setGain sets the gain applied to the samples.
+//
+// TPDF dither is added if this loses resolution, which is when the
+// gain isn't a whole number, for example when reducing the bit depth.
+func (q *quantiser) setGain(gain float64) {
+	q.gain = gain
+	q.dither = gain != math.Trunc(gain)
+}
+
+// quantise the samples in place
+func (q *quantiser) quantise(samples []int) {
+	if q.gain == 1 {
+		return
+	}
+	for i, sample := range samples {
+		x := float64(sample) * q.gain
+		if q.dither {
+			// Triangular dither of +/- 1 LSB
+			x += q.rand.Float64() - q.rand.Float64()
+		}
+		y := int(math.Round(x))
+		if y > q.max {
+			y = q.max
+		} else if y < q.min {
+			y = q.min
+		}
+		samples[i] = y
+	}
+}
@@ -0,0 +1,124 @@
+package cwfile
+
+// MIDI encoding
+//
+// This writes a format 0 Standard MIDI File with a note for each
+// Morse element rather than the audio. The timing is in dits, with a
+// dit as a sixteenth note and the tempo set from the WPM so the file
+// plays back at the right speed.
+//
+// To get the MIDI alongside the audio use it as another output, eg
+// with --out x.wav --out x.mid.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+const (
+	midiTicksPerQuarter = 480
+	midiDitsPerQuarter  = 4 // so a dit is a sixteenth note
+	midiTicksPerDit     = midiTicksPerQuarter / midiDitsPerQuarter
+	midiVelocity        = 100
+	midiNoteOn          = 0x90
+	midiNoteOff         = 0x80
+)
+
+// midiEncoder writes MIDI files from the keying
+type midiEncoder struct {
+	out   io.Writer
+	note  byte
+	track []byte // track events so far
+	delta int    // ticks since the last event
+	down  bool   // state of the key
+}
+
+func newMIDI(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error) {
+	e := &midiEncoder{
+		out:  out,
+		note: midiNote(opt.Frequency),
+	}
+	// Tempo in microseconds per quarter note
+	tempo := midiDitsPerQuarter * cwgenerator.DitDuration(opt.WPM) / time.Microsecond
+	e.meta(0x03, []byte(md.Title)) // track name
+	e.meta(0x51, []byte{byte(tempo >> 16), byte(tempo >> 8), byte(tempo)})
+	return e, nil
+}
+
+// Find the nearest MIDI note to frequency
+func midiNote(frequency float64) byte {
+	note := math.Round(69 + 12*math.Log2(frequency/440))
+	return byte(math.Max(0, math.Min(127, note)))
+}
+
+// Append a variable length quantity to the track
+func (e *midiEncoder) varint(v int) {
+	var buf [5]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7F)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7F) | 0x80
+	}
+	e.track = append(e.track, buf[i:]...)
+}
+
+// Append an event to the track at the current time
+func (e *midiEncoder) event(data ...byte) {
+	e.varint(e.delta)
+	e.delta = 0
+	e.track = append(e.track, data...)
+}
+
+// Append a meta event to the track
+func (e *midiEncoder) meta(kind byte, data []byte) {
+	e.event(0xFF, kind)
+	e.varint(len(data))
+	e.track = append(e.track, data...)
+}
+
+// Key is called with the key state for each dit
+func (e *midiEncoder) Key(down bool) {
+	if down != e.down {
+		if down {
+			e.event(midiNoteOn, e.note, midiVelocity)
+		} else {
+			e.event(midiNoteOff, e.note, 0)
+		}
+		e.down = down
+	}
+	e.delta += midiTicksPerDit
+}
+
+// Write is ignored as the file is made from the keying
+func (e *midiEncoder) Write(samples []int) error {
+	return nil
+}
+
+// Close the encoder writing the file
+func (e *midiEncoder) Close() error {
+	if e.down {
+		e.event(midiNoteOff, e.note, 0)
+	}
+	e.meta(0x2F, nil) // end of track
+	var buf []byte
+	buf = append(buf, "MThd"...)
+	buf = binary.BigEndian.AppendUint32(buf, 6)
+	buf = binary.BigEndian.AppendUint16(buf, 0) // format 0
+	buf = binary.BigEndian.AppendUint16(buf, 1) // tracks
+	buf = binary.BigEndian.AppendUint16(buf, midiTicksPerQuarter)
+	buf = append(buf, "MTrk"...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.track)))
+	buf = append(buf, e.track...)
+	_, err := e.out.Write(buf)
+	if err != nil {
+		return fmt.Errorf("midi: failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package cwfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// midiEvent is an event read back from a MIDI track
+type midiEvent struct {
+	delta  int  // ticks since the previous event
+	status byte // status byte or 0xFF for meta events
+	data   []byte
+}
+
+// parseMIDI reads a format 0 MIDI file returning the ticks per quarter
+// note and the events of its track
+func parseMIDI(t *testing.T, buf []byte) (ticksPerQuarter int, events []midiEvent) {
+	t.Helper()
+	if len(buf) < 22 || string(buf[:4]) != "MThd" || binary.BigEndian.Uint32(buf[4:]) != 6 {
+		t.Fatalf("bad MIDI header % x", buf)
+	}
+	if format, tracks := binary.BigEndian.Uint16(buf[8:]), binary.BigEndian.Uint16(buf[10:]); format != 0 || tracks != 1 {
+		t.Fatalf("got format %d with %d tracks, want format 0 with 1 track", format, tracks)
+	}
+	ticksPerQuarter = int(binary.BigEndian.Uint16(buf[12:]))
+	if string(buf[14:18]) != "MTrk" {
+		t.Fatalf("missing track: % x", buf[14:18])
+	}
+	track := buf[22:]
+	if int(binary.BigEndian.Uint32(buf[18:])) != len(track) {
+		t.Fatalf("track length %d, want %d", binary.BigEndian.Uint32(buf[18:]), len(track))
+	}
+	varint := func() (v int) {
+		for {
+			b := track[0]
+			track = track[1:]
+			v = v<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				return v
+			}
+		}
+	}
+	for len(track) > 0 {
+		e := midiEvent{delta: varint(), status: track[0]}
+		track = track[1:]
+		if e.status == 0xFF {
+			kind := track[0]
+			track = track[1:]
+			n := varint()
+			e.data = append([]byte{kind}, track[:n]...)
+			track = track[n:]
+		} else {
+			e.data = track[:2]
+			track = track[2:]
+		}
+		events = append(events, e)
+	}
+	return ticksPerQuarter, events
+}
+
+func TestMIDI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mid")
+	opt := testOptions(path)
+	opt.WPM = 20 // 60ms dits
+	opt.Frequency = 440
+	opt.Title = "Test"
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.String("E E")
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticksPerQuarter, events := parseMIDI(t, buf)
+	if ticksPerQuarter != 480 {
+		t.Errorf("got %d ticks per quarter note, want 480", ticksPerQuarter)
+	}
+	// The gap between the words is however long the generator
+	// keys it for
+	g := cwgenerator.New(opt)
+	g.String("E E")
+	gap := 0
+	for i := 0; ; i++ {
+		down, found := g.Dit()
+		if !found || (i > 0 && down) {
+			break
+		}
+		if !down {
+			gap++
+		}
+	}
+	const dit = 480 / 4 // a sixteenth note
+	want := []midiEvent{
+		{0, 0xFF, append([]byte{0x03}, "Test"...)},
+		{0, 0xFF, []byte{0x51, 0x03, 0xA9, 0x80}}, // 4 dits of 60ms = 240000µs per quarter note
+		{0, midiNoteOn, []byte{69, midiVelocity}}, // A4 for 440 Hz
+		{dit, midiNoteOff, []byte{69, 0}},
+		{gap * dit, midiNoteOn, []byte{69, midiVelocity}},
+		{dit, midiNoteOff, []byte{69, 0}},
+	}
+	// Ignore the silence after the last element
+	if len(events) < len(want)+1 || !bytes.Equal(events[len(events)-1].data, []byte{0x2F}) {
+		t.Fatalf("got events %v, want %v then end of track", events, want)
+	}
+	if got := events[:len(want)]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events\n%v\nwant\n%v", got, want)
+	}
+}
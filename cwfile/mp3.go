@@ -0,0 +1,592 @@
+package cwfile
+
+// MP3 encoding
+//
+// This is a minimal MPEG-1 Layer III encoder. Morse code is pure
+// tones and silence so it doesn't need a psychoacoustic model. It
+// uses long blocks only with no scalefactors and for each granule
+// chooses the smallest global gain whose Huffman coded spectrum fits
+// in the bits available. Each frame is self contained so there is no
+// bit reservoir.
+//
+// MPEG-1 only supports 32, 44.1 and 48 kHz so the sample rate is
+// raised to one of those if necessary.
+//
+// The usual encoder, LAME, would need cgo and libmp3lame to build and
+// run cwtool. Morse needs so little of the format that this is less
+// to look after, and mp3_test.go checks it with a decoder.
+//
+// See ISO/IEC 11172-3 for the format.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+const (
+	mp3GranuleSamples = 576     // samples per channel in a granule
+	mp3FrameSamples   = 2 * 576 // samples per channel in a frame
+	mp3Subbands       = 32      // subbands from the filterbank
+	mp3SubbandSamples = 18      // samples in each subband per granule
+	mp3MaxPart23      = 1<<12 - 1
+	mp3MaxValue       = 15 + 1<<13 - 1 // largest value table 31 can code
+)
+
+// DefaultMP3Bitrate is the bitrate in kbit/s used if none is set
+const DefaultMP3Bitrate = 64
+
+// MP3Bitrates are the bitrates in kbit/s MP3 output can use
+var MP3Bitrates = []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+
+// mp3SampleRates are the sample rates in the order of their codes in
+// the frame header
+var mp3SampleRates = []int{44100, 48000, 32000}
+
+// Long block scalefactor band boundaries for each sample rate
+var mp3ScalefactorBands = [3][23]int{
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 52, 62, 74, 90, 110, 134, 162, 196, 238, 288, 342, 418, 576},
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 42, 50, 60, 72, 88, 106, 128, 156, 190, 230, 276, 330, 384, 576},
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 54, 66, 82, 102, 126, 156, 194, 240, 296, 364, 448, 550, 576},
+}
+
+// Region counts to split the big values into, indexed by the number
+// of scalefactor bands the big values cover
+var mp3RegionSplit = [23][2]int{
+	{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 1}, {1, 1}, {1, 1},
+	{1, 2}, {2, 2}, {2, 3}, {2, 3}, {3, 4}, {3, 4}, {3, 4}, {4, 5},
+	{4, 5}, {4, 6}, {5, 6}, {5, 6}, {5, 7}, {6, 7}, {6, 7},
+}
+
+// Precomputed transforms
+var (
+	mp3Analysis [mp3Subbands][64]float64       // polyphase filterbank matrix
+	mp3MDCT     [mp3SubbandSamples][36]float64 // windowed MDCT
+	mp3AliasCS  [8]float64                     // alias reduction butterflies
+	mp3AliasCA  [8]float64
+	mp3Gain     [256]float64 // quantiser step for each global gain
+)
+
+// Coefficients for the alias reduction butterflies
+var mp3AliasCoeffs = [8]float64{-0.6, -0.535, -0.33, -0.185, -0.095, -0.041, -0.0142, -0.0037}
+
+func init() {
+	for i := range mp3Analysis {
+		for k := range mp3Analysis[i] {
+			mp3Analysis[i][k] = math.Cos(float64((2*i+1)*(k-16)) * math.Pi / 64)
+		}
+	}
+	// The MDCT is scaled by 1/9 to undo the gain of the decoder's IMDCT
+	for k := range mp3MDCT {
+		for n := range mp3MDCT[k] {
+			window := math.Sin(math.Pi/36*(float64(n)+0.5)) / 9
+			mp3MDCT[k][n] = window * math.Cos(math.Pi/72*float64((2*n+1+18)*(2*k+1)))
+		}
+	}
+	for i, c := range mp3AliasCoeffs {
+		sq := math.Sqrt(1 + c*c)
+		mp3AliasCS[i] = 1 / sq
+		mp3AliasCA[i] = c / sq
+	}
+	for i := range mp3Gain {
+		mp3Gain[i] = math.Pow(2, -0.1875*float64(i-210))
+	}
+}
+
+// mp3Granule is the side info and quantised spectrum for one channel
+// of a granule
+type mp3Granule struct {
+	part23Length int
+	bigValues    int
+	globalGain   int
+	tableSelect  [3]int
+	regionCount  [2]int
+	ix           [mp3GranuleSamples]int
+}
+
+// mp3Encoder writes MP3 files
+type mp3Encoder struct {
+	out             io.Writer
+	channels        int
+	sampleRate      int
+	bitrate         int // bits/s
+	bitrateIndex    int
+	sampleRateIndex int
+	slotRemainder   int       // to work out when to pad frames
+	pcm             []float64 // interleaved samples waiting for a frame
+	history         [][512]float64
+	subbands        [][2][mp3Subbands][mp3SubbandSamples]float64 // previous and current granule for each channel
+	xr              [mp3GranuleSamples]float64
+	xr34            [mp3GranuleSamples]float64 // |xr|**0.75
+	granules        [2][2]mp3Granule
+	bw              bitWriter
+}
+
+func newMP3(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error) {
+	e := &mp3Encoder{
+		out:             out,
+		channels:        opt.Channels,
+		sampleRate:      opt.SampleRate,
+		bitrateIndex:    -1,
+		sampleRateIndex: -1,
+	}
+	if e.channels < 1 || e.channels > 2 {
+		return nil, fmt.Errorf("mp3: can't encode %d channels", e.channels)
+	}
+	if opt.BitDepthInBytes != 2 {
+		return nil, fmt.Errorf("mp3: can't encode %d bits per sample", 8*opt.BitDepthInBytes)
+	}
+	for i, sampleRate := range mp3SampleRates {
+		if sampleRate == e.sampleRate {
+			e.sampleRateIndex = i
+		}
+	}
+	if e.sampleRateIndex < 0 {
+		return nil, fmt.Errorf("mp3: can't encode at %d Hz", e.sampleRate)
+	}
+	bitrate := opt.Bitrate
+	if bitrate == 0 {
+		bitrate = DefaultMP3Bitrate
+	}
+	for i, b := range MP3Bitrates {
+		if b == bitrate {
+			e.bitrateIndex = i + 1
+		}
+	}
+	if e.bitrateIndex < 0 {
+		return nil, fmt.Errorf("mp3: bitrate must be one of %s kbit/s not %d", strings.Trim(fmt.Sprint(MP3Bitrates), "[]"), bitrate)
+	}
+	e.bitrate = bitrate * 1000
+	e.history = make([][512]float64, e.channels)
+	e.subbands = make([][2][mp3Subbands][mp3SubbandSamples]float64, e.channels)
+	e.pcm = make([]float64, 0, mp3FrameSamples*e.channels)
+
+	tag := id3v2([][2]string{
+		{"TIT2", md.Title},
+		{"TALB", md.Title},
+		{"TPE1", md.Artist},
+		{"TSSE", md.Software},
+	})
+	_, err := out.Write(tag)
+	if err != nil {
+		return nil, fmt.Errorf("mp3: failed to write ID3 tag: %w", err)
+	}
+	return e, nil
+}
+
+// Make an ID3v2.4 tag with UTF-8 text frames, leaving out empty ones
+func id3v2(frames [][2]string) []byte {
+	var body []byte
+	for _, frame := range frames {
+		id, text := frame[0], frame[1]
+		if text == "" {
+			continue
+		}
+		body = append(body, id...)
+		body = append(body, syncsafe(len(text)+1)...)
+		body = append(body, 0, 0) // flags
+		body = append(body, 3)    // UTF-8
+		body = append(body, text...)
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0}
+	tag = append(tag, syncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+// Encode n as an ID3v2 syncsafe integer using 7 bits of each byte
+func syncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
+
+// Write the interleaved samples
+func (e *mp3Encoder) Write(samples []int) error {
+	for _, sample := range samples {
+		e.pcm = append(e.pcm, float64(sample)/32768)
+		if len(e.pcm) == cap(e.pcm) {
+			err := e.writeFrame()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write a frame from e.pcm
+func (e *mp3Encoder) writeFrame() error {
+	// Pad a short frame with silence
+	for len(e.pcm) < cap(e.pcm) {
+		e.pcm = append(e.pcm, 0)
+	}
+
+	// Work out the frame size, padding with an extra byte often
+	// enough to keep the bitrate exact
+	const slotsPerFrame = mp3FrameSamples / 8
+	frameBytes := slotsPerFrame * e.bitrate / e.sampleRate
+	e.slotRemainder += slotsPerFrame * e.bitrate % e.sampleRate
+	padding := 0
+	if e.slotRemainder >= e.sampleRate {
+		e.slotRemainder -= e.sampleRate
+		padding = 1
+	}
+	frameBytes += padding
+	sideInfoBits := 256
+	if e.channels == 1 {
+		sideInfoBits = 136
+	}
+	budget := (frameBytes*8 - 32 - sideInfoBits) / (2 * e.channels)
+	if budget > mp3MaxPart23 {
+		budget = mp3MaxPart23
+	}
+
+	// Transform and quantise each granule
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < e.channels; ch++ {
+			e.analyse(ch, e.pcm[gr*mp3GranuleSamples*e.channels:])
+			e.quantise(&e.granules[gr][ch], budget)
+		}
+	}
+	e.pcm = e.pcm[:0]
+
+	// Header
+	bw := &e.bw
+	bw.writeBits(0xFFF, 12) // sync
+	bw.writeBits(1, 1)      // MPEG-1
+	bw.writeBits(1, 2)      // layer III
+	bw.writeBits(1, 1)      // no CRC
+	bw.writeBits(uint64(e.bitrateIndex), 4)
+	bw.writeBits(uint64(e.sampleRateIndex), 2)
+	bw.writeBits(uint64(padding), 1)
+	bw.writeBits(0, 1) // private
+	if e.channels == 1 {
+		bw.writeBits(3, 2) // mono
+	} else {
+		bw.writeBits(0, 2) // stereo
+	}
+	bw.writeBits(0, 2) // mode extension
+	bw.writeBits(0, 1) // copyright
+	bw.writeBits(1, 1) // original
+	bw.writeBits(0, 2) // emphasis
+
+	// Side info
+	bw.writeBits(0, 9) // main_data_begin - no bit reservoir
+	if e.channels == 1 {
+		bw.writeBits(0, 5) // private bits
+	} else {
+		bw.writeBits(0, 3)
+	}
+	bw.writeBits(0, 4*uint(e.channels)) // scfsi
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < e.channels; ch++ {
+			g := &e.granules[gr][ch]
+			bw.writeBits(uint64(g.part23Length), 12)
+			bw.writeBits(uint64(g.bigValues), 9)
+			bw.writeBits(uint64(g.globalGain), 8)
+			bw.writeBits(0, 4) // scalefac_compress - no scalefactors
+			bw.writeBits(0, 1) // window_switching_flag - long blocks
+			for _, table := range g.tableSelect {
+				bw.writeBits(uint64(table), 5)
+			}
+			bw.writeBits(uint64(g.regionCount[0]), 4)
+			bw.writeBits(uint64(g.regionCount[1]), 3)
+			bw.writeBits(0, 1) // preflag
+			bw.writeBits(0, 1) // scalefac_scale
+			bw.writeBits(0, 1) // count1table_select
+		}
+	}
+
+	// Main data
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < e.channels; ch++ {
+			e.writeHuffman(&e.granules[gr][ch])
+		}
+	}
+	bw.align()
+	for len(bw.buf) < frameBytes {
+		bw.buf = append(bw.buf, 0)
+	}
+	_, err := e.out.Write(bw.buf)
+	bw.reset()
+	if err != nil {
+		return fmt.Errorf("mp3: failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// Transform the next granule for channel ch from the interleaved pcm
+// into the spectrum in e.xr
+func (e *mp3Encoder) analyse(ch int, pcm []float64) {
+	x := &e.history[ch]
+	sb := &e.subbands[ch]
+	sb[0] = sb[1]
+
+	// Polyphase filterbank into 32 subbands
+	var y [64]float64
+	for t := 0; t < mp3SubbandSamples; t++ {
+		copy(x[32:], x[:512-32])
+		for i := 0; i < 32; i++ {
+			x[31-i] = pcm[(t*32+i)*e.channels+ch]
+		}
+		for i := range y {
+			sum := 0.0
+			for j := i; j < 512; j += 64 {
+				sum += mp3Window[j] * x[j]
+			}
+			y[i] = sum / 32
+		}
+		for i := range mp3Analysis {
+			sum := 0.0
+			for k, v := range y {
+				sum += mp3Analysis[i][k] * v
+			}
+			sb[1][i][t] = sum
+		}
+	}
+
+	// Undo the frequency inversion of the odd subbands
+	for i := 1; i < mp3Subbands; i += 2 {
+		for t := 1; t < mp3SubbandSamples; t += 2 {
+			sb[1][i][t] = -sb[1][i][t]
+		}
+	}
+
+	// MDCT each subband over this and the previous granule
+	for i := 0; i < mp3Subbands; i++ {
+		for k := range mp3MDCT {
+			sum := 0.0
+			for n := 0; n < mp3SubbandSamples; n++ {
+				sum += mp3MDCT[k][n]*sb[0][i][n] + mp3MDCT[k][n+mp3SubbandSamples]*sb[1][i][n]
+			}
+			e.xr[i*mp3SubbandSamples+k] = sum
+		}
+	}
+
+	// Alias reduction butterflies between adjacent subbands
+	for i := 1; i < mp3Subbands; i++ {
+		for k := range mp3AliasCS {
+			lo := i*mp3SubbandSamples - 1 - k
+			hi := i*mp3SubbandSamples + k
+			bu, bd := e.xr[lo], e.xr[hi]
+			e.xr[lo] = bu*mp3AliasCS[k] + bd*mp3AliasCA[k]
+			e.xr[hi] = bd*mp3AliasCS[k] - bu*mp3AliasCA[k]
+		}
+	}
+}
+
+// Quantise e.xr into g with the smallest global gain which fits into
+// budget bits
+func (e *mp3Encoder) quantise(g *mp3Granule, budget int) {
+	silent := true
+	for i, v := range e.xr {
+		e.xr34[i] = math.Pow(math.Abs(v), 0.75)
+		if e.xr34[i] != 0 {
+			silent = false
+		}
+	}
+	// Binary search the global gain - the bits used falls as it rises
+	lo, hi := 0, 255
+	if silent {
+		lo = hi
+	}
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if e.quantiseGain(g, mid) <= budget {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	g.part23Length = e.quantiseGain(g, hi)
+	if g.part23Length > budget {
+		// Can't happen as everything quantises to 0 at the
+		// largest gain, but make sure the frame stays valid
+		g.ix = [mp3GranuleSamples]int{}
+		g.part23Length = mp3Choose(g, mp3ScalefactorBands[e.sampleRateIndex][:])
+	}
+}
+
+// Quantise e.xr into g with globalGain returning the number of bits
+// it takes or a very large number if it can't be coded
+func (e *mp3Encoder) quantiseGain(g *mp3Granule, globalGain int) int {
+	g.globalGain = globalGain
+	step := mp3Gain[globalGain]
+	for i, v := range e.xr34 {
+		ix := int(v*step + 0.4054)
+		if ix > mp3MaxValue {
+			return math.MaxInt32
+		}
+		if e.xr[i] < 0 {
+			ix = -ix
+		}
+		g.ix[i] = ix
+	}
+	return mp3Choose(g, mp3ScalefactorBands[e.sampleRateIndex][:])
+}
+
+// Choose the regions and Huffman tables for g.ix returning the number
+// of bits they need
+func mp3Choose(g *mp3Granule, bands []int) (bits int) {
+	// Everything after the big values must be zero as count1 isn't used
+	last := len(g.ix) - 1
+	for last >= 0 && g.ix[last] == 0 {
+		last--
+	}
+	g.bigValues = (last + 2) / 2
+	g.tableSelect = [3]int{}
+	g.regionCount = [2]int{}
+	end := 2 * g.bigValues
+	if end == 0 {
+		return 0
+	}
+
+	// Split into regions on scalefactor band boundaries
+	bandsUsed := 0
+	for bands[bandsUsed] < end {
+		bandsUsed++
+	}
+	region0 := mp3RegionSplit[bandsUsed][0]
+	for region0 > 0 && bands[region0+1] > end {
+		region0--
+	}
+	region1 := mp3RegionSplit[bandsUsed][1]
+	for region1 > 0 && bands[region0+region1+2] > end {
+		region1--
+	}
+	g.regionCount = [2]int{region0, region1}
+	starts := [4]int{0, bands[region0+1], bands[region0+region1+2], end}
+	for i := 1; i < len(starts); i++ {
+		if starts[i] > end {
+			starts[i] = end
+		}
+	}
+	for i := range g.tableSelect {
+		table, regionBits := mp3ChooseTable(g.ix[starts[i]:starts[i+1]])
+		g.tableSelect[i] = table
+		bits += regionBits
+	}
+	return bits
+}
+
+// Choose the Huffman table which codes ix in the fewest bits
+func mp3ChooseTable(ix []int) (bestTable, bestBits int) {
+	max := 0
+	for _, v := range ix {
+		if v < 0 {
+			v = -v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return 0, 0
+	}
+	bestBits = math.MaxInt32
+	var tree *mp3HuffmanTable // last linbits tree tried
+	for table, t := range mp3HuffmanTables {
+		// Larger linbits in the same tree can only cost more
+		if t.table == nil || (t.linbits != 0 && t.table == tree) {
+			continue
+		}
+		if t.linbits == 0 {
+			if max >= t.table.xlen {
+				continue
+			}
+		} else if max > 15+1<<t.linbits-1 {
+			continue
+		}
+		if t.linbits != 0 {
+			tree = t.table
+		}
+		bits := mp3RegionBits(table, ix)
+		if bits < bestBits {
+			bestTable, bestBits = table, bits
+		}
+	}
+	return bestTable, bestBits
+}
+
+// Split v into its magnitude for the Huffman code and any linbits
+func mp3Split(v int, linbits uint) (code int, extra int, sign int) {
+	if v < 0 {
+		v = -v
+		sign = 1
+	}
+	if linbits > 0 && v >= 15 {
+		return 15, v - 15, sign
+	}
+	return v, 0, sign
+}
+
+// Count the bits to code the pairs in ix with table
+func mp3RegionBits(table int, ix []int) (bits int) {
+	t := mp3HuffmanTables[table]
+	for i := 0; i+1 < len(ix); i += 2 {
+		x, _, _ := mp3Split(ix[i], t.linbits)
+		y, _, _ := mp3Split(ix[i+1], t.linbits)
+		bits += int(t.table.lengths[x*t.table.xlen+y])
+		if x != 0 {
+			bits++
+		}
+		if y != 0 {
+			bits++
+		}
+		if x == 15 && t.linbits > 0 {
+			bits += int(t.linbits)
+		}
+		if y == 15 && t.linbits > 0 {
+			bits += int(t.linbits)
+		}
+	}
+	return bits
+}
+
+// Write the Huffman coded big values of g
+func (e *mp3Encoder) writeHuffman(g *mp3Granule) {
+	bands := mp3ScalefactorBands[e.sampleRateIndex]
+	region1Start := bands[g.regionCount[0]+1]
+	region2Start := bands[g.regionCount[0]+g.regionCount[1]+2]
+	for i := 0; i < 2*g.bigValues; i += 2 {
+		table := g.tableSelect[0]
+		if i >= region2Start {
+			table = g.tableSelect[2]
+		} else if i >= region1Start {
+			table = g.tableSelect[1]
+		}
+		if table == 0 {
+			continue
+		}
+		t := mp3HuffmanTables[table]
+		x, xExtra, xSign := mp3Split(g.ix[i], t.linbits)
+		y, yExtra, ySign := mp3Split(g.ix[i+1], t.linbits)
+		n := x*t.table.xlen + y
+		e.bw.writeBits(uint64(t.table.codes[n]), uint(t.table.lengths[n]))
+		if x == 15 && t.linbits > 0 {
+			e.bw.writeBits(uint64(xExtra), t.linbits)
+		}
+		if x != 0 {
+			e.bw.writeBits(uint64(xSign), 1)
+		}
+		if y == 15 && t.linbits > 0 {
+			e.bw.writeBits(uint64(yExtra), t.linbits)
+		}
+		if y != 0 {
+			e.bw.writeBits(uint64(ySign), 1)
+		}
+	}
+}
+
+// Close flushes the buffered samples and the delay through the
+// filterbank with a frame of silence
+func (e *mp3Encoder) Close() error {
+	if len(e.pcm) > 0 {
+		err := e.writeFrame()
+		if err != nil {
+			return err
+		}
+	}
+	return e.writeFrame()
+}
@@ -0,0 +1,138 @@
+package cwfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/ncw/cwtool/cw"
+)
+
+// correlation returns the largest normalised cross correlation of
+// got against want over lags up to maxLag samples
+func correlation(want, got []float64, maxLag int) (best float64, bestLag int) {
+	for lag := 0; lag <= maxLag && lag < len(got); lag++ {
+		var sum, sumWant, sumGot float64
+		for i, w := range want {
+			if i+lag >= len(got) {
+				break
+			}
+			g := got[i+lag]
+			sum += w * g
+			sumWant += w * w
+			sumGot += g * g
+		}
+		if sumWant == 0 || sumGot == 0 {
+			continue
+		}
+		c := sum / math.Sqrt(sumWant*sumGot)
+		if c > best {
+			best, bestLag = c, lag
+		}
+	}
+	return best, bestLag
+}
+
+func TestMP3RoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		channels   int
+		sampleRate int
+		bitrate    int
+	}{
+		{"mono32k64", 1, 32000, 64},
+		{"mono44k128", 1, 44100, 128},
+		{"stereo48k128", 2, 48000, 128},
+		{"mono32k32", 1, 32000, 32},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opt := &cw.Options{
+				SampleRate:      test.sampleRate,
+				Channels:        test.channels,
+				BitDepthInBytes: 2,
+				Bitrate:         test.bitrate,
+			}
+			// Half a second of keyed 600 Hz tone like Morse
+			n := test.sampleRate / 2
+			want := make([]float64, n)
+			samples := make([]int, 0, n*test.channels)
+			for i := range want {
+				if (i*20/test.sampleRate)%2 == 0 {
+					want[i] = 0.3 * 32767 * math.Sin(2*math.Pi*600*float64(i)/float64(test.sampleRate))
+				}
+				for ch := 0; ch < test.channels; ch++ {
+					samples = append(samples, int(math.Round(want[i])))
+				}
+			}
+			var buf bytes.Buffer
+			e, err := newMP3(&buf, opt, &Metadata{Title: "Test", Software: "cwtool"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = e.Write(samples)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = e.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := mp3.NewDecoder(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.SampleRate() != test.sampleRate {
+				t.Fatalf("decoded at %d Hz, want %d Hz", d.SampleRate(), test.sampleRate)
+			}
+			pcm, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The decoder always makes 16 bit stereo
+			frames := len(pcm) / 4
+			left := make([]float64, frames)
+			right := make([]float64, frames)
+			for i := range left {
+				left[i] = float64(int16(binary.LittleEndian.Uint16(pcm[4*i:])))
+				right[i] = float64(int16(binary.LittleEndian.Uint16(pcm[4*i+2:])))
+			}
+			if frames < n {
+				t.Fatalf("decoded %d samples, want at least %d", frames, n)
+			}
+			for ch, got := range [][]float64{left, right} {
+				c, lag := correlation(want, got, 2*mp3FrameSamples)
+				t.Logf("channel %d: correlation %.4f at lag %d", ch, c, lag)
+				if c < 0.99 {
+					t.Errorf("channel %d: correlation %.4f at lag %d, want at least 0.99", ch, c, lag)
+				}
+			}
+		})
+	}
+}
+
+// The sample rate is raised for MP3 without changing the caller's
+// options, which other outputs may share
+func TestMP3SampleRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	opt := testOptions(path)
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.String("E")
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.SampleRate != 8000 {
+		t.Errorf("New changed the sample rate in the options to %d", opt.SampleRate)
+	}
+	if p.opt.SampleRate != 32000 {
+		t.Errorf("MP3 written at %d Hz, want 32000", p.opt.SampleRate)
+	}
+}
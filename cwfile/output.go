@@ -0,0 +1,237 @@
+package cwfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// output is where an encoded file is written
+type output interface {
+	io.Writer
+
+	// Commit finishes the output successfully
+	Commit() error
+
+	// Abort finishes the output unsuccessfully, removing anything
+	// partially written if possible
+	Abort() error
+}
+
+// Stdout is the OutputFile name which sends the output to stdout
+const Stdout = "-"
+
+// TCP is the prefix of an OutputFile which sends the output to a TCP
+// connection, eg "tcp:localhost:1234"
+const TCP = "tcp:"
+
+// How long to wait to connect to a TCP output
+const dialTimeout = 10 * time.Second
+
+// stream is an output which can't be seeked or replaced, such as
+// stdout, a pipe or a device. It hides any Seek method so the
+// encoders stream.
+type stream struct {
+	io.Writer
+	closer io.Closer // to close when finished if set
+}
+
+// Close the stream if necessary
+func (s stream) close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// Commit closes the stream
+func (s stream) Commit() error {
+	return s.close()
+}
+
+// Abort closes the stream - the output has already been sent
+func (s stream) Abort() error {
+	return s.close()
+}
+
+// File is an output file made by Create
+type File interface {
+	io.Writer
+
+	// Commit finishes the file successfully, moving it into place
+	Commit() error
+
+	// Abort finishes the file unsuccessfully, removing it
+	Abort() error
+}
+
+// Create makes a File for path in the same way as the audio outputs
+// are made, so regular files are written atomically and won't
+// overwrite an existing file unless force is set.
+//
+// path may also be Stdout or a TCP address.
+func Create(path string, force bool) (File, error) {
+	return createOutput(path, force)
+}
+
+// createOutput makes the output for path, which may be Stdout or a
+// TCP address.
+//
+// Regular files are written atomically with createFile, but anything
+// else which already exists, like a named pipe or /dev/stdout, is
+// written to directly as a stream.
+func createOutput(path string, force bool) (output, error) {
+	if path == Stdout {
+		return stream{Writer: os.Stdout}, nil
+	}
+	if strings.HasPrefix(path, TCP) {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(path, TCP), dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return stream{Writer: conn, closer: conn}, nil
+	}
+	fi, err := os.Stat(path)
+	if err == nil && !fi.Mode().IsRegular() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return stream{Writer: f, closer: f}, nil
+	}
+	return createFile(path, force)
+}
+
+// atomicFile is written to a temporary file in the same directory
+// which is renamed into place when it is committed, so the file at
+// path is never seen half written.
+type atomicFile struct {
+	*os.File
+	path  string
+	force bool // overwrite an existing file at path
+}
+
+// The atomicFiles which haven't been committed or aborted
+var (
+	pendingMu sync.Mutex
+	pending   = map[*atomicFile]struct{}{}
+)
+
+// RemoveTemporaryFiles removes the temporary files of any outputs
+// which haven't been finished. Call it if the program is exiting
+// early, eg when interrupted, so they aren't left behind.
+func RemoveTemporaryFiles() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for f := range pending {
+		_ = f.File.Close()
+		_ = os.Remove(f.Name())
+		delete(pending, f)
+	}
+}
+
+// existsError makes the error for an output file which exists already
+func existsError(path string) error {
+	return fmt.Errorf("%q already exists - use --force to overwrite it", path)
+}
+
+// createFile creates an atomicFile for path.
+//
+// Unless force is set it refuses to overwrite an existing file.
+func createFile(path string, force bool) (*atomicFile, error) {
+	if !force {
+		if _, err := os.Lstat(path); err == nil {
+			return nil, existsError(path)
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files only the owner can read
+	err = f.Chmod(0644)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	af := &atomicFile{File: f, path: path, force: force}
+	pendingMu.Lock()
+	pending[af] = struct{}{}
+	pendingMu.Unlock()
+	return af, nil
+}
+
+// Mark f as finished
+func (f *atomicFile) finished() {
+	pendingMu.Lock()
+	delete(pending, f)
+	pendingMu.Unlock()
+}
+
+// Commit closes the file and moves it into place.
+//
+// Unless force is set this refuses to overwrite a file which was
+// created at path after the atomicFile was.
+func (f *atomicFile) Commit() error {
+	defer f.finished()
+	err := f.File.Close()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if !f.force {
+		// Link, unlike Rename, fails if path exists
+		err = os.Link(f.Name(), f.path)
+		if err == nil {
+			_ = os.Remove(f.Name())
+			return nil
+		}
+		if errors.Is(err, os.ErrExist) {
+			_ = os.Remove(f.Name())
+			return existsError(f.path)
+		}
+		// The file system can't make links so check before renaming
+		if _, err := os.Lstat(f.path); err == nil {
+			_ = os.Remove(f.Name())
+			return existsError(f.path)
+		}
+	}
+	err = os.Rename(f.Name(), f.path)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort closes the file and removes it
+func (f *atomicFile) Abort() error {
+	defer f.finished()
+	return errors.Join(f.File.Close(), os.Remove(f.Name()))
+}
+
+// writeFile atomically writes path with fn, refusing to overwrite an
+// existing file unless force is set
+func writeFile(path string, force bool, fn func(w *bufio.Writer) error) error {
+	f, err := createFile(path, force)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return errors.Join(err, f.Abort())
+	}
+	return f.Commit()
+}
@@ -0,0 +1,53 @@
+package cwfile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// rawEncoder writes headerless PCM - signed little endian samples
+// with the channels interleaved
+type rawEncoder struct {
+	out   io.Writer
+	bytes int // bytes per sample
+	buf   []byte
+}
+
+func newRaw(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error) {
+	if opt.BitDepthInBytes < 1 || opt.BitDepthInBytes > 4 {
+		return nil, fmt.Errorf("raw: can't encode %d bits per sample", 8*opt.BitDepthInBytes)
+	}
+	e := &rawEncoder{
+		out:   out,
+		bytes: opt.BitDepthInBytes,
+	}
+	return e, nil
+}
+
+// putSamples appends the samples to buf as little endian values of
+// bytes each
+func putSamples(buf []byte, samples []int, bytes int) []byte {
+	for _, sample := range samples {
+		for i := 0; i < bytes; i++ {
+			buf = append(buf, byte(sample>>(8*i)))
+		}
+	}
+	return buf
+}
+
+// Write the interleaved samples
+func (e *rawEncoder) Write(samples []int) error {
+	e.buf = putSamples(e.buf[:0], samples, e.bytes)
+	_, err := e.out.Write(e.buf)
+	if err != nil {
+		return fmt.Errorf("raw: failed to write samples: %w", err)
+	}
+	return nil
+}
+
+// Close the encoder - there is nothing to flush
+func (e *rawEncoder) Close() error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package cwfile
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// Encoder writes samples to the output in a particular format
+type Encoder interface {
+	// Write the samples which are interleaved by channel
+	Write(samples []int) error
+
+	// Close the encoder flushing any buffered output and finishing
+	// the headers. This doesn't close the underlying file.
+	Close() error
+}
+
+// Keyer is implemented by Encoders which are made from the keying
+// rather than the audio
+type Keyer interface {
+	// Key is called with whether the key is down for each dit
+	Key(down bool)
+}
+
+// Metadata to put into the output file
+type Metadata struct {
+	Title    string
+	Artist   string
+	Software string
+}
+
+// NewEncoderFunc makes a new Encoder.
+//
+// If out is an io.WriteSeeker then the encoder may seek to update
+// the headers when it is closed, otherwise it must stream.
+type NewEncoderFunc func(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error)
+
+// EncoderInfo describes an output format for Register
+type EncoderInfo struct {
+	Name        string         // name of the format for --format, eg "wav"
+	Description string         // one line description
+	Extensions  []string       // file extensions without the "." - Name is used if empty
+	SampleRates []int          // sample rates supported in ascending order - nil for any
+	Bits        []int          // bits per sample supported in ascending order - nil for 8, 16, 24 and 32
+	New         NewEncoderFunc // make a new encoder
+}
+
+// closest returns the closest value in supported to v, rounding up
+// if possible, or v if supported is empty
+func closest(supported []int, v int) int {
+	if len(supported) == 0 {
+		return v
+	}
+	for _, s := range supported {
+		if s >= v {
+			return s
+		}
+	}
+	return supported[len(supported)-1]
+}
+
+// sampleRate returns the closest supported sample rate to sampleRate,
+// rounding up if possible
+func (info *EncoderInfo) sampleRate(sampleRate int) int {
+	return closest(info.SampleRates, sampleRate)
+}
+
+// bits returns the closest supported bits per sample to bits,
+// rounding up if possible
+func (info *EncoderInfo) bits(bits int) int {
+	return closest(info.Bits, bits)
+}
+
+var (
+	registryMu sync.RWMutex
+	formats    = map[string]*EncoderInfo{} // by name
+	extensions = map[string]*EncoderInfo{} // by extension
+)
+
+// Register an output format so it can be used by name with --format
+// or chosen by the extension of --out.
+//
+// This is intended to be called from an init function. It panics if
+// the name or an extension is already registered.
+func Register(info *EncoderInfo) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	name := strings.ToLower(info.Name)
+	if info.New == nil || name == "" {
+		panic("cwfile: Register needs a Name and New")
+	}
+	if _, found := formats[name]; found {
+		panic(fmt.Sprintf("cwfile: format %q registered twice", name))
+	}
+	exts := info.Extensions
+	if len(exts) == 0 {
+		exts = []string{name}
+	}
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if _, found := extensions[ext]; found {
+			panic(fmt.Sprintf("cwfile: extension %q registered twice", ext))
+		}
+		extensions[ext] = info
+	}
+	formats[name] = info
+}
+
+func init() {
+	Register(&EncoderInfo{
+		Name:        "wav",
+		Description: "WAV audio with optional cue points and a bext chunk",
+		Extensions:  []string{"wav", "wave"},
+		New:         newWAV,
+	})
+	Register(&EncoderInfo{
+		Name:        "flac",
+		Description: "FLAC lossless compressed audio",
+		Bits:        []int{8, 16, 24},
+		New:         newFLAC,
+	})
+	Register(&EncoderInfo{
+		Name:        "raw",
+		Description: "Headerless signed little endian PCM",
+		Extensions:  []string{"raw", "pcm"},
+		New:         newRaw,
+	})
+	Register(&EncoderInfo{
+		Name:        "mp3",
+		Description: "MPEG-1 Layer III compressed audio",
+		SampleRates: []int{32000, 44100, 48000},
+		Bits:        []int{16},
+		New:         newMP3,
+	})
+	Register(&EncoderInfo{
+		Name:        "mid",
+		Description: "Standard MIDI File of the keying",
+		Extensions:  []string{"mid", "midi"},
+		New:         newMIDI,
+	})
+}
+
+// Encoders returns the registered formats sorted by name
+func Encoders() (infos []*EncoderInfo) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, info := range formats {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Formats returns the names of the registered formats
+func Formats() (names []string) {
+	for _, info := range Encoders() {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+// lookup finds the format for name
+func lookup(name string) *EncoderInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return formats[strings.ToLower(name)]
+}
+
+// Format returns the name of the format to use for opt.
+//
+// This is opt.Format if set, otherwise it is found from the
+// extension of opt.OutputFile, defaulting to WAV.
+func Format(opt *cw.Options) (string, error) {
+	if opt.Format != "" {
+		info := lookup(opt.Format)
+		if info == nil {
+			return "", fmt.Errorf("unknown output format %q: must be one of %s", opt.Format, strings.Join(Formats(), ", "))
+		}
+		return info.Name, nil
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(opt.OutputFile), "."))
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if info, found := extensions[ext]; found {
+		return info.Name, nil
+	}
+	return "wav", nil
+}
@@ -0,0 +1,335 @@
+package cwfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// Values of cw.Options.Split other than a duration
+const (
+	// SplitItem makes a file per item
+	SplitItem = "item"
+
+	// SplitParagraph makes a file per paragraph. This is the same
+	// as SplitItem but the caller uses Item to mark the start of
+	// each paragraph.
+	SplitParagraph = "paragraph"
+)
+
+// Longest title used in the name of a part
+const maxSlugLength = 40
+
+// part is one of the files written by the Splitter
+type part struct {
+	number   int
+	file     string
+	title    string
+	items    []string // text of each item in the part
+	duration time.Duration
+}
+
+// Splitter writes the Morse into a numbered series of files, starting
+// a new one for each item or after a given duration, with a playlist
+// and an answer key.
+//
+// An item is the text sent between calls to Sync, unless Item is
+// used to say where each item starts and what it is called.
+type Splitter struct {
+	opt      *cw.Options
+	perItem  bool          // start a new file each item
+	duration time.Duration // otherwise start a new file after this long
+	base     string        // OutputFile without its extension
+	ext      string        // extension of OutputFile
+	player   *Player       // current part, nil if none
+	text     []rune        // text of the current item not written yet
+	parts    []part
+	marked   bool   // set once Item has been called
+	number   int    // number of the current item if marked
+	title    string // title of the current item if marked
+	inItem   bool   // set if the current item is in the answer key of the current part
+	err      error  // error from Item to return from Sync or Close
+}
+
+// NewSplitter makes a Splitter writing files named after
+// opt.OutputFile, split according to opt.Split which should be
+// SplitItem, SplitParagraph or a duration.
+func NewSplitter(opt *cw.Options) (*Splitter, error) {
+	if opt.OutputFile == "" || opt.OutputFile == Stdout || strings.HasPrefix(opt.OutputFile, TCP) {
+		return nil, errors.New("splitting needs an output file")
+	}
+	s := &Splitter{
+		opt: opt,
+		ext: filepath.Ext(opt.OutputFile),
+	}
+	s.base = strings.TrimSuffix(opt.OutputFile, s.ext)
+	if opt.Split == SplitItem || opt.Split == SplitParagraph {
+		s.perItem = true
+	} else {
+		var err error
+		s.duration, err = time.ParseDuration(opt.Split)
+		if err != nil || s.duration <= 0 {
+			return nil, fmt.Errorf("split must be %q, %q or a duration like 5m, not %q", SplitItem, SplitParagraph, opt.Split)
+		}
+	}
+	// Check the format before any files are written
+	if _, err := Format(opt); err != nil {
+		return nil, err
+	}
+	if opt.Playlist != "" {
+		if _, err := playlistFormat(opt.Playlist); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Rune adds r to the output
+func (s *Splitter) Rune(r rune) {
+	s.text = append(s.text, r)
+}
+
+// String adds s to the output
+func (s *Splitter) String(text string) {
+	s.text = append(s.text, []rune(text)...)
+}
+
+// Make a file name safe slug from title
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			dash = false
+			b.WriteRune(r)
+			if b.Len() >= maxSlugLength {
+				break
+			}
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// Start a new part numbered number titled with title
+func (s *Splitter) openPart(number int, title string) error {
+	file := fmt.Sprintf("%s-%03d", s.base, number)
+	if slug := slug(title); slug != "" {
+		file += "-" + slug
+	}
+	file += s.ext
+
+	opt := *s.opt
+	opt.OutputFile = file
+	opt.Title = title
+	if opt.Subtitles != "" {
+		opt.Subtitles = strings.TrimSuffix(file, s.ext) + filepath.Ext(opt.Subtitles)
+	}
+	player, err := New(&opt)
+	if err != nil {
+		return err
+	}
+	s.player = player
+	s.parts = append(s.parts, part{
+		number: number,
+		file:   file,
+		title:  title,
+	})
+	return nil
+}
+
+// Finish the current part
+func (s *Splitter) closePart() error {
+	if s.player == nil {
+		return nil
+	}
+	err := s.player.Close()
+	s.parts[len(s.parts)-1].duration = s.player.Duration()
+	s.player = nil
+	return err
+}
+
+// Write the text to the current part starting a new one if
+// necessary.
+//
+// Unless Item has been called this ends the current item.
+func (s *Splitter) flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	text := strings.Join(strings.Fields(string(s.text)), " ")
+	if text == "" {
+		s.text = s.text[:0]
+		return nil
+	}
+	if s.player == nil {
+		number := len(s.parts) + 1
+		if s.marked && s.perItem {
+			number = s.number
+		}
+		title := s.title
+		if !s.marked || title == "" {
+			// Leave any BT separating the items off the title
+			title = strings.TrimSpace(strings.TrimSuffix(text, "="))
+			if title == "" {
+				title = text
+			}
+		}
+		err := s.openPart(number, title)
+		if err != nil {
+			return err
+		}
+		s.inItem = false
+	}
+	s.player.String(string(s.text))
+	s.text = s.text[:0]
+	err := s.player.Sync()
+	if err != nil {
+		return err
+	}
+	p := &s.parts[len(s.parts)-1]
+	if s.marked && s.inItem {
+		p.items[len(p.items)-1] += " " + text
+	} else {
+		p.items = append(p.items, text)
+		s.inItem = true
+	}
+	if s.marked {
+		// Items are ended by Item
+		return nil
+	}
+	return s.endItem()
+}
+
+// End the current item, finishing the current part if it is full
+func (s *Splitter) endItem() error {
+	s.inItem = false
+	if s.player != nil && (s.perItem || s.player.Duration() >= s.duration) {
+		return s.closePart()
+	}
+	return nil
+}
+
+// Item starts item number called title.
+//
+// Once this has been called the items are the text between calls to
+// Item rather than Sync, and with SplitItem the files are numbered
+// and named after the items. Any error is returned by the next Sync or
+// Close.
+func (s *Splitter) Item(number int, title string) {
+	err := s.flush()
+	if err == nil {
+		err = s.endItem()
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.marked = true
+	s.number = number
+	s.title = title
+}
+
+// Sync writes the text so far to the current file, ending the current
+// item unless Item is being used
+func (s *Splitter) Sync() error {
+	return s.flush()
+}
+
+// Close the output, writing the playlist and the answer key
+func (s *Splitter) Close() error {
+	err := s.flush()
+	if err != nil {
+		return errors.Join(err, s.closePart())
+	}
+	err = s.closePart()
+	if err != nil {
+		return err
+	}
+	playlist := s.opt.Playlist
+	if playlist == "" {
+		playlist = s.base + ".m3u"
+	}
+	return errors.Join(
+		writePlaylist(playlist, s.parts, s.opt.Force),
+		s.writeAnswers(s.base+".txt"),
+	)
+}
+
+// Write the text of each part as an answer key
+func (s *Splitter) writeAnswers(file string) error {
+	err := writeFile(file, s.opt.Force, func(w *bufio.Writer) error {
+		for _, p := range s.parts {
+			fmt.Fprintf(w, "%s\n", filepath.Base(p.file))
+			for _, item := range p.items {
+				fmt.Fprintf(w, "    %s\n", item)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write answer key: %w", err)
+	}
+	return nil
+}
+
+// Find the playlist format for file from its extension
+func playlistFormat(file string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	if ext != "m3u" && ext != "pls" {
+		return "", fmt.Errorf("unknown playlist format for %q: extension must be m3u or pls", file)
+	}
+	return ext, nil
+}
+
+// Write the parts as a playlist in the format given by the extension
+// of file with the paths relative to it
+func writePlaylist(file string, parts []part, force bool) error {
+	format, err := playlistFormat(file)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	path := func(p part) string {
+		rel, err := filepath.Rel(dir, p.file)
+		if err != nil {
+			return p.file
+		}
+		return rel
+	}
+	err = writeFile(file, force, func(w *bufio.Writer) error {
+		if format == "pls" {
+			fmt.Fprintf(w, "[playlist]\n")
+			for i, p := range parts {
+				fmt.Fprintf(w, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", i+1, path(p), i+1, p.title, i+1, int(p.duration.Round(time.Second)/time.Second))
+			}
+			fmt.Fprintf(w, "NumberOfEntries=%d\nVersion=2\n", len(parts))
+			return nil
+		}
+		fmt.Fprintf(w, "#EXTM3U\n")
+		for _, p := range parts {
+			fmt.Fprintf(w, "#EXTINF:%d,%s\n%s\n", int(p.duration.Round(time.Second)/time.Second), p.title, path(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
+
+// Check interfaces
+var (
+	_ cw.CW       = (*Splitter)(nil)
+	_ cw.Itemiser = (*Splitter)(nil)
+)
@@ -0,0 +1,117 @@
+package cwfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// play sends each of texts to c ending each with a Sync
+func play(t *testing.T, c cw.CW, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		c.String(text + " = ")
+		err := c.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listDir returns the names of the files in dir
+func listDir(t *testing.T, dir string) (names []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestSplitterSync(t *testing.T) {
+	dir := t.TempDir()
+	opt := testOptions(filepath.Join(dir, "test.wav"))
+	opt.Split = SplitItem
+	s, err := NewSplitter(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play(t, s, "CQ", "DE G4XYZ")
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-001-cq.wav", "test-002-de-g4xyz.wav", "test.m3u", "test.txt"}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestSplitterItem(t *testing.T) {
+	dir := t.TempDir()
+	opt := testOptions(filepath.Join(dir, "test.wav"))
+	opt.Split = SplitItem
+	s, err := NewSplitter(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Item(0, "Feed")
+	play(t, s, "FEED", "ABOUT")
+	s.Item(1, "First item")
+	play(t, s, "NR 1", "FIRST ITEM", "TEXT")
+	s.Item(2, "Second")
+	play(t, s, "NR 2", "SECOND")
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-000-feed.wav", "test-001-first-item.wav", "test-002-second.wav", "test.m3u", "test.txt"}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	answers, err := os.ReadFile(filepath.Join(dir, "test.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAnswers := `test-000-feed.wav
+    FEED = ABOUT =
+
+test-001-first-item.wav
+    NR 1 = FIRST ITEM = TEXT =
+
+test-002-second.wav
+    NR 2 = SECOND =
+
+`
+	if string(answers) != wantAnswers {
+		t.Errorf("got answers\n%s\nwant\n%s", answers, wantAnswers)
+	}
+}
+
+func TestSplitterItemDuration(t *testing.T) {
+	dir := t.TempDir()
+	opt := testOptions(filepath.Join(dir, "test.wav"))
+	opt.Split = "1h"
+	s, err := NewSplitter(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, title := range []string{"One", "Two"} {
+		s.Item(i+1, title)
+		play(t, s, title, "TEXT")
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-001-one.wav", "test.m3u", "test.txt"}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+package cwfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// How long to show the last subtitle for after its Morse has finished
+const subtitleHold = 2 * time.Second
+
+// subtitle is some text to show between Start and End
+type subtitle struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// writeSubtitlesFunc writes subtitles in a particular format
+type writeSubtitlesFunc func(out io.Writer, subtitles []subtitle, md *Metadata) error
+
+// subtitleFormats maps the extension of each subtitle format onto
+// the function to write it
+var subtitleFormats = map[string]writeSubtitlesFunc{
+	"srt": writeSRT,
+	"vtt": writeVTT,
+	"lrc": writeLRC,
+}
+
+// SubtitleFormats returns the extensions of the supported subtitle formats
+func SubtitleFormats() (names []string) {
+	for name := range subtitleFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find the subtitle format for file from its extension
+func subtitleFormat(file string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	if _, found := subtitleFormats[ext]; !found {
+		return "", fmt.Errorf("unknown subtitle format for %q: extension must be one of %s", file, strings.Join(SubtitleFormats(), ", "))
+	}
+	return ext, nil
+}
+
+// Turn the labels into subtitles.
+//
+// Each subtitle is shown as its Morse starts, or if opt.SubtitleDelay
+// is set, that long after its Morse finishes so it has been heard
+// first. It is shown until subtitleHold after that, or until the next
+// one starts if that is sooner.
+func makeSubtitles(labels []label, opt *cw.Options) []subtitle {
+	toDuration := func(samples int) time.Duration {
+		return time.Duration(samples) * time.Second / time.Duration(opt.SampleRate)
+	}
+	subtitles := make([]subtitle, len(labels))
+	for i, l := range labels {
+		end := toDuration(l.Start+l.Length) + opt.SubtitleDelay
+		start := toDuration(l.Start)
+		if opt.SubtitleDelay > 0 {
+			start = end
+		}
+		subtitles[i] = subtitle{
+			Start: start,
+			End:   end + subtitleHold,
+			Text:  l.Text,
+		}
+		if i > 0 && subtitles[i-1].End > subtitles[i].Start {
+			subtitles[i-1].End = subtitles[i].Start
+		}
+	}
+	return subtitles
+}
+
+// Write the labels as subtitles to out in the format given by the
+// extension of file
+func writeSubtitles(out io.Writer, file string, labels []label, opt *cw.Options, md *Metadata) error {
+	format, err := subtitleFormat(file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = subtitleFormats[format](w, makeSubtitles(labels, opt), md)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s subtitle file: %w", format, err)
+	}
+	return nil
+}
+
+// Format d as hours, minutes, seconds and milliseconds with sep before
+// the milliseconds
+func subtitleTime(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// Write SubRip subtitles
+func writeSRT(out io.Writer, subtitles []subtitle, md *Metadata) error {
+	for i, s := range subtitles {
+		_, err := fmt.Fprintf(out, "%d\n%s --> %s\n%s\n\n", i+1, subtitleTime(s.Start, ","), subtitleTime(s.End, ","), s.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escape text for WebVTT
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Write WebVTT subtitles
+func writeVTT(out io.Writer, subtitles []subtitle, md *Metadata) error {
+	_, err := fmt.Fprintf(out, "WEBVTT\n\n")
+	if err != nil {
+		return err
+	}
+	for _, s := range subtitles {
+		_, err := fmt.Fprintf(out, "%s --> %s\n%s\n\n", subtitleTime(s.Start, "."), subtitleTime(s.End, "."), vttEscaper.Replace(s.Text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format d as minutes, seconds and hundredths for LRC
+func lrcTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("[%02d:%02d.%02d]", cs/6000, cs/100%60, cs%100)
+}
+
+// Write LRC lyrics
+//
+// LRC has no end times so an empty line clears the text when there is
+// a gap before the next subtitle.
+func writeLRC(out io.Writer, subtitles []subtitle, md *Metadata) error {
+	title := strings.ReplaceAll(md.Title, "\n", " ")
+	_, err := fmt.Fprintf(out, "[ti:%s]\n[ar:%s]\n[re:%s]\n", title, md.Artist, md.Artist)
+	if err != nil {
+		return err
+	}
+	for i, s := range subtitles {
+		_, err = fmt.Fprintf(out, "%s%s\n", lrcTime(s.Start), s.Text)
+		if err != nil {
+			return err
+		}
+		if i == len(subtitles)-1 || subtitles[i+1].Start > s.End {
+			_, err = fmt.Fprintf(out, "%s\n", lrcTime(s.End))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
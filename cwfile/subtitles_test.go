@@ -0,0 +1,40 @@
+package cwfile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+func TestMakeSubtitles(t *testing.T) {
+	// At 1000 samples per second a sample is a millisecond
+	labels := []label{
+		{Start: 0, Length: 1000, Text: "CQ"},
+		{Start: 1500, Length: 500, Text: "DE"},
+		{Start: 10000, Length: 1000, Text: "G4ABC"},
+	}
+	ms := time.Millisecond
+	for _, test := range []struct {
+		delay time.Duration
+		want  []subtitle
+	}{
+		{0, []subtitle{
+			{0, 1500 * ms, "CQ"},
+			{1500 * ms, 4000 * ms, "DE"},
+			{10000 * ms, 13000 * ms, "G4ABC"},
+		}},
+		{3 * time.Second, []subtitle{
+			{4000 * ms, 5000 * ms, "CQ"},
+			{5000 * ms, 7000 * ms, "DE"},
+			{14000 * ms, 16000 * ms, "G4ABC"},
+		}},
+	} {
+		opt := &cw.Options{SampleRate: 1000, SubtitleDelay: test.delay}
+		got := makeSubtitles(labels, opt)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("delay %v: got %v, want %v", test.delay, got, test.want)
+		}
+	}
+}
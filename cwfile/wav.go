@@ -0,0 +1,253 @@
+package cwfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/ncw/cwtool/cw"
+)
+
+// wavEncoder writes WAV files
+type wavEncoder struct {
+	out     io.WriteSeeker
+	encoder *wav.Encoder
+	buf     audio.IntBuffer // buffer to send to output
+	labels  []label         // to write as cue points
+	bext    []byte          // bext chunk to write if set
+}
+
+func newWAV(out io.Writer, opt *cw.Options, md *Metadata) (Encoder, error) {
+	ws, ok := out.(io.WriteSeeker)
+	if !ok {
+		return newWAVStream(out, opt)
+	}
+	encoder := wav.NewEncoder(ws,
+		opt.SampleRate,
+		8*opt.BitDepthInBytes,
+		opt.Channels,
+		1, // PCM format
+	)
+	encoder.Metadata = &wav.Metadata{
+		Title:    md.Title,
+		Product:  md.Title,
+		Software: md.Software,
+		Artist:   md.Artist,
+	}
+	e := &wavEncoder{
+		out:     ws,
+		encoder: encoder,
+		buf: audio.IntBuffer{
+			Format: &audio.Format{
+				NumChannels: opt.Channels,
+				SampleRate:  opt.SampleRate,
+			},
+			SourceBitDepth: 8 * opt.BitDepthInBytes,
+		},
+	}
+	if opt.BEXT {
+		e.bext = wavBEXT(opt, md, time.Now())
+	}
+	return e, nil
+}
+
+// Write the interleaved samples
+func (e *wavEncoder) Write(samples []int) error {
+	if e.buf.SourceBitDepth == 8 {
+		// 8 bit WAV samples are unsigned
+		for i := range samples {
+			samples[i] += 128
+		}
+	}
+	e.buf.Data = samples
+	return e.encoder.Write(&e.buf)
+}
+
+// Label adds labels to be written as cue points
+func (e *wavEncoder) Label(labels []label) {
+	e.labels = append(e.labels, labels...)
+}
+
+// Close the encoder writing the header and any cue points and bext
+// chunk
+func (e *wavEncoder) Close() error {
+	err := e.encoder.Close()
+	if err != nil {
+		return err
+	}
+	var chunks []byte
+	if e.bext != nil {
+		chunks = appendChunk(chunks, "bext", e.bext)
+	}
+	if len(e.labels) > 0 {
+		chunks = appendChunk(chunks, "cue ", wavCue(e.labels))
+		chunks = appendChunk(chunks, "LIST", wavADTL(e.labels))
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	// Append the chunks then update the RIFF size
+	end, err := e.out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("wav: failed to seek to end: %w", err)
+	}
+	if end%2 != 0 {
+		// The chunk before wasn't padded to an even length
+		chunks = append([]byte{0}, chunks...)
+	}
+	_, err = e.out.Write(chunks)
+	if err != nil {
+		return fmt.Errorf("wav: failed to write cue points: %w", err)
+	}
+	_, err = e.out.Seek(4, io.SeekStart)
+	if err == nil {
+		err = binary.Write(e.out, binary.LittleEndian, uint32(end)+uint32(len(chunks))-8)
+	}
+	if err == nil {
+		_, err = e.out.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return fmt.Errorf("wav: failed to update RIFF size: %w", err)
+	}
+	return nil
+}
+
+// Append a RIFF chunk with id and data to buf padding it to an even length
+func appendChunk(buf []byte, id string, data []byte) []byte {
+	buf = append(buf, id...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	if len(data)%2 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Make the body of a cue chunk with a cue point for the start of each
+// label. The cue point IDs are the label index + 1.
+func wavCue(labels []label) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(labels)))
+	for i, l := range labels {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(i+1))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(l.Start)) // position
+		buf = append(buf, "data"...)
+		buf = binary.LittleEndian.AppendUint32(buf, 0) // chunk start
+		buf = binary.LittleEndian.AppendUint32(buf, 0) // block start
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(l.Start))
+	}
+	return buf
+}
+
+// Make the body of a LIST/adtl chunk with the text of each label and
+// the length of the region it covers
+func wavADTL(labels []label) []byte {
+	buf := []byte("adtl")
+	for i, l := range labels {
+		id := uint32(i + 1)
+		labl := binary.LittleEndian.AppendUint32(nil, id)
+		labl = append(labl, l.Text...)
+		labl = append(labl, 0)
+		buf = appendChunk(buf, "labl", labl)
+		if l.Length > 0 {
+			ltxt := binary.LittleEndian.AppendUint32(nil, id)
+			ltxt = binary.LittleEndian.AppendUint32(ltxt, uint32(l.Length))
+			ltxt = append(ltxt, "rgn "...)
+			ltxt = append(ltxt, make([]byte, 8)...) // country, language, dialect, code page
+			buf = appendChunk(buf, "ltxt", ltxt)
+		}
+	}
+	return buf
+}
+
+// Make the body of a Broadcast Wave bext chunk (version 1)
+func wavBEXT(opt *cw.Options, md *Metadata, now time.Time) []byte {
+	field := func(buf []byte, s string, n int) []byte {
+		b := make([]byte, n)
+		copy(b, s)
+		return append(buf, b...)
+	}
+	mode := "mono"
+	if opt.Channels == 2 {
+		mode = "stereo"
+	}
+	var buf []byte
+	buf = field(buf, md.Title, 256) // Description
+	buf = field(buf, md.Artist, 32) // Originator
+	buf = field(buf, "", 32)        // OriginatorReference
+	buf = field(buf, now.Format("2006-01-02"), 10)
+	buf = field(buf, now.Format("15:04:05"), 8)
+	buf = binary.LittleEndian.AppendUint64(buf, 0) // TimeReference
+	buf = binary.LittleEndian.AppendUint16(buf, 1) // Version
+	buf = field(buf, "", 64)                       // UMID
+	buf = field(buf, "", 190)                      // Reserved
+	codingHistory := fmt.Sprintf("A=PCM,F=%d,W=%d,M=%s,T=%s\r\n", opt.SampleRate, 8*opt.BitDepthInBytes, mode, md.Artist)
+	return append(buf, codingHistory...)
+}
+
+// wavStreamEncoder writes WAV files which can't be seeked to fill in
+// the sizes at the end, for example to a pipe.
+//
+// The RIFF and data chunk sizes are set to the maximum which most
+// readers take to mean read until the end of the stream.
+type wavStreamEncoder struct {
+	out   io.Writer
+	bytes int // bytes per sample
+	buf   []byte
+}
+
+// Size used for chunks of unknown length
+const wavUnknownSize = 0xFFFFFFFF
+
+func newWAVStream(out io.Writer, opt *cw.Options) (Encoder, error) {
+	e := &wavStreamEncoder{
+		out:   out,
+		bytes: opt.BitDepthInBytes,
+	}
+	if e.bytes < 1 || e.bytes > 4 {
+		return nil, fmt.Errorf("wav: can't encode %d bits per sample", 8*e.bytes)
+	}
+	blockAlign := opt.Channels * e.bytes
+	header := []byte("RIFF")
+	header = binary.LittleEndian.AppendUint32(header, wavUnknownSize)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM format
+	header = binary.LittleEndian.AppendUint16(header, uint16(opt.Channels))
+	header = binary.LittleEndian.AppendUint32(header, uint32(opt.SampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(opt.SampleRate*blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(8*e.bytes))
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, wavUnknownSize)
+	_, err := out.Write(header)
+	if err != nil {
+		return nil, fmt.Errorf("wav: failed to write header: %w", err)
+	}
+	return e, nil
+}
+
+// Write the interleaved samples
+func (e *wavStreamEncoder) Write(samples []int) error {
+	if e.bytes == 1 {
+		// 8 bit WAV samples are unsigned
+		for i := range samples {
+			samples[i] += 128
+		}
+	}
+	e.buf = putSamples(e.buf[:0], samples, e.bytes)
+	_, err := e.out.Write(e.buf)
+	if err != nil {
+		return fmt.Errorf("wav: failed to write samples: %w", err)
+	}
+	return nil
+}
+
+// Close the encoder - the header can't be updated so there is
+// nothing to do
+func (e *wavStreamEncoder) Close() error {
+	return nil
+}
@@ -0,0 +1,204 @@
+package cwgenerator
+
+// Real time keying
+//
+// Outputs which aren't audio, like keying a serial port line or
+// showing the Morse in the terminal, play the Morse a dit at a time
+// from the generator with a Clock.
+
+import (
+	"sync"
+	"time"
+)
+
+// Keying says what a Clock does as it plays the Morse. Any of the
+// functions may be nil.
+type Keying struct {
+	// Start is called before the first dit after Idle. It may
+	// block, eg while a transmitter turns on, and the dits are timed
+	// from when it returns.
+	Start func()
+
+	// Key is called at the start of each dit with the number of
+	// dits played before it and whether the key is down for it.
+	Key func(n int, down bool)
+
+	// Stop is called at the end of the last dit once all the Morse
+	// added has been played, with the number of dits played.
+	Stop func(n int)
+
+	// Idle is called when no more Morse has been added for Hold
+	// after Stop, and before the Clock finishes when it is closed.
+	Idle func()
+
+	// Hold is how long to wait for more Morse before calling Idle
+	Hold time.Duration
+}
+
+// Clock plays the Morse from a Generator in real time a dit at a time
+type Clock struct {
+	generator *Generator
+	keying    Keying
+	dit       time.Duration // length of each dit
+	wake      chan struct{} // sent to when Morse is added or the Clock is closed
+	finished  chan struct{} // closed when the Clock has finished
+
+	mu      sync.Mutex
+	queued  uint64        // incremented each time Morse is added
+	played  uint64        // value of queued when the Morse was last all played
+	done    chan struct{} // closed when everything queued has been played
+	closing bool          // set when the Clock is being closed
+}
+
+// NewClock starts playing the Morse added to generator with keying,
+// each dit lasting dit.
+//
+// Call Queue after adding Morse to the generator.
+func NewClock(generator *Generator, dit time.Duration, keying Keying) *Clock {
+	c := &Clock{
+		generator: generator,
+		keying:    keying,
+		dit:       dit,
+		wake:      make(chan struct{}, 1),
+		finished:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	close(c.done) // nothing to play yet
+	go c.run()
+	return c
+}
+
+// Sleep for d or return early if woken, returning whether woken
+func (c *Clock) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-c.wake:
+		return true
+	}
+}
+
+// run plays the Morse as it is added until the Clock is closed
+func (c *Clock) run() {
+	defer close(c.finished)
+	var (
+		next    time.Time // when the next dit starts
+		n       int       // dits played so far
+		started bool      // set if Start has been called since Idle
+		playing bool      // set if Stop hasn't been called since the last dit
+	)
+	for {
+		c.mu.Lock()
+		queued, closing := c.queued, c.closing
+		c.mu.Unlock()
+
+		down, found := c.generator.Dit()
+		if !found {
+			// Finish the last dit then wait for more Morse
+			time.Sleep(time.Until(next))
+			if playing {
+				if c.keying.Stop != nil {
+					c.keying.Stop(n)
+				}
+				playing = false
+			}
+			c.finish(queued)
+			if started {
+				if !closing && c.sleep(c.keying.Hold) {
+					continue
+				}
+				if closing {
+					time.Sleep(c.keying.Hold)
+				}
+				if c.keying.Idle != nil {
+					c.keying.Idle()
+				}
+				started = false
+			}
+			if closing {
+				return
+			}
+			<-c.wake
+			continue
+		}
+
+		if !started {
+			if c.keying.Start != nil {
+				c.keying.Start()
+			}
+			started = true
+		}
+		now := time.Now()
+		if next.Before(now) {
+			next = now
+		}
+		if c.keying.Key != nil {
+			c.keying.Key(n, down)
+		}
+		playing = true
+		n++
+		next = next.Add(c.dit)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// finish marks the Morse up to queued as played
+func (c *Clock) finish(queued uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if queued > c.played {
+		c.played = queued
+	}
+	if c.played == c.queued {
+		select {
+		case <-c.done:
+		default:
+			close(c.done)
+		}
+	}
+}
+
+// Queue notes that Morse has been added to the generator and wakes
+// the Clock up
+func (c *Clock) Queue() {
+	c.mu.Lock()
+	c.queued++
+	select {
+	case <-c.done:
+		c.done = make(chan struct{})
+	default:
+	}
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Done returns a channel which is closed when all the Morse queued so
+// far has been played.
+//
+// Queueing more Morse makes a new channel so Done should be called
+// again afterwards.
+func (c *Clock) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Close the Clock after all the Morse has been played and Idle called
+func (c *Clock) Close() {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	<-c.finished
+}
@@ -2,12 +2,11 @@
 package cwgenerator
 
 import (
-	"fmt"
 	"io"
+	"log"
 	"math"
 	"sync"
 	"time"
-	"unicode"
 
 	"github.com/ncw/cwtool/cw"
 )
@@ -15,38 +14,83 @@ import (
 // Generator contains state for the Morse generation
 type Generator struct {
 	opt          *cw.Options
-	sequenceMu   sync.Mutex // hold mutex when adding/removing things from sequence
-	sequence     []byte     // sequence to play samples in
-	sampleLength int        // length of sample in bytes
-	samples      [2][]byte  // samples to play
-	sampleIndex  byte       // index of sample we are playing now
-	sampleOffset int        // how far we've got through that sample
-	extraDits    int        // extra dits after each letter
+	sequenceMu   sync.Mutex      // hold mutex when adding/removing things from sequence
+	sequence     []byte          // sequence to play samples in
+	waveform                     // samples for the current speed and pitch
+	next         *waveform       // if set use this waveform from the next dit
+	sampleIndex  byte            // index of sample we are playing now
+	sampleOffset int             // how far we've got through that sample
+	wpm          float64         // current speed
+	extraDits    int             // extra dits after each letter
+	dits         int             // dits added to the sequence so far
+	read         int             // dits read from the sequence so far
+	paused       bool            // if set don't read any more from the sequence
+	lastTone     int             // the value of dits after the last tone added
+	marking      bool            // set if recording marks
+	marks        []Mark          // marks recorded since the last call to Marks
+	keying       func(down bool) // if set called with the key state of each dit as it is read
+}
+
+// waveform holds the samples for each symbol at a given speed and
+// pitch
+type waveform struct {
+	sampleLength  int       // length of sample in bytes
+	samples       [2][]byte // samples to play
+	samplesPerDit int       // samples per channel in each dit
+}
+
+// Mark is the position of a rune in the generated audio
+type Mark struct {
+	Rune   rune // the rune as passed to Rune
+	Start  int  // offset of the first sample of the rune in samples per channel
+	Length int  // samples until the end of its last element, 0 if it has none
 }
 
 // New makes a new player with the Options passed in
 func New(opt *cw.Options) *Generator {
 	cw := &Generator{
 		opt: opt,
+		wpm: opt.WPM,
 	}
+	cw.waveform = *cw._configure(opt.WPM, opt.Frequency)
+	return cw
+}
+
+// Reconfigure changes the speed and pitch of the Morse.
+//
+// The new pitch and speed apply from the next dit read, though
+// Farnsworth spacing only changes for Morse added afterwards. This
+// is intended for players - the positions of any Marks after this
+// are in dits of the new speed.
+func (cw *Generator) Reconfigure(wpm, frequency float64) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	cw.wpm = wpm
+	cw.next = cw._configure(wpm, frequency)
+}
 
-	ditTimeSeconds := wpmToDitTime(opt.WPM)
-	cyclesPerDit := opt.Frequency * ditTimeSeconds
+// Work out the Farnsworth spacing and make the waveform for wpm and
+// frequency, call with lock held or before use
+func (cw *Generator) _configure(wpm, frequency float64) *waveform {
+	opt := cw.opt
+	ditTimeSeconds := wpmToDitTime(wpm)
+	cyclesPerDit := frequency * ditTimeSeconds
 	if cw.opt.Debug {
-		fmt.Printf("cyclesPerDit = %.3f at %.1f Hz\n", cyclesPerDit, opt.Frequency)
+		log.Printf("cyclesPerDit = %.3f at %.1f Hz", cyclesPerDit, frequency)
 	}
 	// Round cycles per dit to an exact number to avoid clicks
 	// this changes the frequency slightly
 	cyclesPerDit = math.Round(cyclesPerDit)
 	newFrequency := cyclesPerDit / ditTimeSeconds
 	if cw.opt.Debug {
-		fmt.Printf("cyclesPerDit = %.3f at %.1f Hz\n", cyclesPerDit, newFrequency)
+		log.Printf("cyclesPerDit = %.3f at %.1f Hz", cyclesPerDit, newFrequency)
 	}
 
 	// Compute number of extra dit times to meet Farnsworth target
-	if opt.Farnsworth > 0 && opt.Farnsworth < opt.WPM {
+	cw.extraDits = 0
+	if opt.Farnsworth > 0 && opt.Farnsworth < wpm {
 		// Time to send one PARIS word is
-		wordTimeNormal := 60 / opt.WPM
+		wordTimeNormal := 60 / wpm
 		// Time to send one PARIS word at the Farnsworth speed is
 		wordTimeFarnsworth := 60 / opt.Farnsworth
 		// So we need to slow each word down by this much
@@ -63,54 +107,143 @@ func New(opt *cw.Options) *Generator {
 			cw.extraDits = 1
 		}
 		if cw.opt.Debug {
-			fmt.Printf("Farnsworth at %.1f WPM using %.1f WPM needs %.1f extra dits\n", opt.Farnsworth, opt.WPM, extraDits)
+			log.Printf("Farnsworth at %.1f WPM using %.1f WPM needs %.1f extra dits", opt.Farnsworth, wpm, extraDits)
 			actualWordTime := wordTimeNormal + 6*ditTimeSeconds*float64(cw.extraDits)
 			actualWPM := 60 / actualWordTime
-			fmt.Printf("This rounds to %d extra dits which makes an actual Farnsworth of %.1f WPM\n", cw.extraDits, actualWPM)
+			log.Printf("This rounds to %d extra dits which makes an actual Farnsworth of %.1f WPM", cw.extraDits, actualWPM)
 		}
 	}
 
-	samplesPerDit := int(math.Round(float64(opt.SampleRate) * ditTimeSeconds))
+	w := &waveform{}
+	w.samplesPerDit = int(math.Round(float64(opt.SampleRate) * ditTimeSeconds))
 	sampleWidth := opt.Channels * opt.BitDepthInBytes
-	cw.sampleLength = samplesPerDit * sampleWidth
-	cw.samples[0] = make([]byte, cw.sampleLength)
-	cw.samples[1] = make([]byte, cw.sampleLength)
-	dit := cw.samples[1]
-	for i := 0; i < samplesPerDit; i++ {
-		b := int16(math.Sin(2*math.Pi*float64(i)/float64(samplesPerDit)*cyclesPerDit) * 0.3 * float64(opt.MaxSampleValue))
+	w.sampleLength = w.samplesPerDit * sampleWidth
+	w.samples[0] = make([]byte, w.sampleLength)
+	w.samples[1] = make([]byte, w.sampleLength)
+	dit := w.samples[1]
+	gain := DefaultGain
+	if opt.Level != 0 {
+		gain = LevelToGain(opt.Level)
+	}
+	amplitude := gain * float64(opt.MaxSampleValue)
+	for i := 0; i < w.samplesPerDit; i++ {
+		b := int16(math.Round(math.Sin(2*math.Pi*float64(i)/float64(w.samplesPerDit)*cyclesPerDit) * amplitude))
 		for ch := 0; ch < opt.Channels; ch++ {
 			dit[sampleWidth*i+2*ch] = byte(b)
 			dit[sampleWidth*i+1+2*ch] = byte(b >> 8)
 		}
 	}
-	return cw
+	return w
+}
+
+// DefaultGain is the peak amplitude of the tone as a fraction of full
+// scale if cw.Options.Level isn't set, about -10.5 dBFS
+const DefaultGain = 0.3
+
+// LevelToGain converts a level in dB into a linear gain
+func LevelToGain(level float64) float64 {
+	return math.Pow(10, level/20)
 }
 
 // Read a symbol from the sequence or return not found
 func (cw *Generator) in() (symbol byte, found bool) {
 	cw.sequenceMu.Lock()
 	defer cw.sequenceMu.Unlock()
-	if len(cw.sequence) <= 0 {
+	if cw.next != nil {
+		cw.waveform = *cw.next
+		cw.next = nil
+	}
+	if cw.paused || len(cw.sequence) <= 0 {
 		return 0, false
 	}
 	symbol, cw.sequence = cw.sequence[0], cw.sequence[1:]
+	cw.read++
 	return symbol, true
 }
 
 // Add things to output sequence, call with lock held
 func (cw *Generator) _out(symbols ...byte) {
 	cw.sequence = append(cw.sequence, symbols...)
+	for _, symbol := range symbols {
+		cw.dits++
+		if symbol != 0 {
+			cw.lastTone = cw.dits
+		}
+	}
 }
 
-// Clear empties the sequence and resets the state
+// Clear empties the sequence.
+//
+// Any dit being read is finished so it doesn't click.
 func (cw *Generator) Clear() {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	cw.dits -= len(cw.sequence)
 	cw.sequence = cw.sequence[:0]
-	cw.sampleOffset = 0
+}
+
+// Pause stops the sequence being read if paused is set, or carries on
+// reading it if not.
+//
+// The generator reads as if it had run out of Morse while paused.
+// Any dit being read is finished so it doesn't click.
+func (cw *Generator) Pause(paused bool) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	cw.paused = paused
+}
+
+// Position returns how many dits have been read and how many have
+// been added to the sequence so far
+func (cw *Generator) Position() (read, added int) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	return cw.read, cw.dits
+}
+
+// SetKeying sets fn to be called from Read as each dit starts with
+// whether the key is down (a tone) or up (silence) for that dit.
+//
+// This gives the timing of the elements exactly, in units of dits.
+func (cw *Generator) SetKeying(fn func(down bool)) {
+	cw.keying = fn
+}
+
+// TrackMarks makes the generator record a Mark for each rune added
+// from now on which can be read with Marks
+func (cw *Generator) TrackMarks() {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	cw.marking = true
+}
+
+// Marks returns the marks recorded since the last call to Marks
+func (cw *Generator) Marks() (marks []Mark) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	marks, cw.marks = cw.marks, nil
+	return marks
+}
+
+// Record a mark for r if it added anything since start, call with
+// lock held
+func (cw *Generator) _mark(r rune, start int) {
+	if !cw.marking || cw.dits == start {
+		return
+	}
+	mark := Mark{
+		Rune:  r,
+		Start: start * cw.samplesPerDit,
+	}
+	if cw.lastTone > start {
+		mark.Length = (cw.lastTone - start) * cw.samplesPerDit
+	}
+	cw.marks = append(cw.marks, mark)
 }
 
 // Time it should take to play the Morse
 func (cw *Generator) duration() time.Duration {
-	return time.Duration((float64(len(cw.sequence)) * wpmToDitTime(cw.opt.WPM)) * float64(time.Second))
+	return time.Duration((float64(len(cw.sequence)) * wpmToDitTime(cw.wpm)) * float64(time.Second))
 }
 
 // Read implements the io.Reader interface for the sound data
@@ -128,6 +261,9 @@ func (cw *Generator) Read(buf []byte) (n int, err error) {
 				}
 				break
 			}
+			if cw.keying != nil {
+				cw.keying(cw.sampleIndex != 0)
+			}
 		}
 
 		// Get its waveform
@@ -147,6 +283,16 @@ func (cw *Generator) Read(buf []byte) (n int, err error) {
 	return n, err
 }
 
+// Dit reads the next dit from the sequence without making any audio,
+// returning whether the key is down for it, or found false if the
+// sequence is empty.
+//
+// Use this instead of Read to key hardware with the Morse timing.
+func (cw *Generator) Dit() (down, found bool) {
+	symbol, found := cw.in()
+	return symbol != 0, found
+}
+
 // Add Farnsworth spacing
 func (cw *Generator) _extraDits() {
 	for i := 0; i < cw.extraDits; i++ {
@@ -154,19 +300,8 @@ func (cw *Generator) _extraDits() {
 	}
 }
 
-// Adds the rune to the output
-func (cw *Generator) Rune(r rune) {
-	cw.sequenceMu.Lock()
-	defer cw.sequenceMu.Unlock()
-
-	r = unicode.ToUpper(r)
-	code := morseCode[r]
-	if code == "" {
-		if cw.opt.Debug {
-			fmt.Printf("Don't know how to play '%c'\n", r)
-		}
-		return
-	}
+// Adds the code for a single character to the output, call with lock held
+func (cw *Generator) _code(code string) {
 	for _, c := range code {
 		switch c {
 		case '-':
@@ -190,6 +325,28 @@ func (cw *Generator) Rune(r rune) {
 	cw._extraDits()
 }
 
+// Adds the rune to the output
+//
+// Runes with no Morse code are dealt with according to the Unknown
+// policy in the options.
+func (cw *Generator) Rune(r rune) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	defer cw._mark(r, cw.dits)
+
+	code := morseCode[normalise(r)]
+	if code == "" {
+		if cw.opt.Debug {
+			log.Printf("Don't know how to play '%c'", r)
+		}
+		for _, code := range unknownCodes(cw.opt, r) {
+			cw._code(code)
+		}
+		return
+	}
+	cw._code(code)
+}
+
 // Adds the string to the output
 func (cw *Generator) String(s string) {
 	for _, r := range s {
@@ -197,5 +354,15 @@ func (cw *Generator) String(s string) {
 	}
 }
 
+// Silence adds d of silence to the output, rounded to the nearest dit
+func (cw *Generator) Silence(d time.Duration) {
+	cw.sequenceMu.Lock()
+	defer cw.sequenceMu.Unlock()
+	dits := int(math.Round(d.Seconds() / wpmToDitTime(cw.wpm)))
+	for i := 0; i < dits; i++ {
+		cw._out(0)
+	}
+}
+
 // check interfaces
 var _ io.Reader = (*Generator)(nil)
@@ -1,5 +1,7 @@
 package cwgenerator
 
+import "time"
+
 var morseCode = map[rune]string{
 	'A': ".-",
 	'B': "-...",
@@ -68,3 +70,8 @@ var morseCode = map[rune]string{
 func wpmToDitTime(wpm float64) float64 {
 	return 60 / (50 * wpm)
 }
+
+// DitDuration returns the time each dit takes at wpm
+func DitDuration(wpm float64) time.Duration {
+	return time.Duration(wpmToDitTime(wpm) * float64(time.Second))
+}
@@ -0,0 +1,189 @@
+package cwgenerator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// Notation describes how Morse code is written down as text
+type Notation struct {
+	Dot       rune   // written for a dit
+	Dash      rune   // written for a dah
+	LetterGap string // written between letters
+	WordGap   string // written between words
+}
+
+// ASCIINotation writes Morse code like .- -... / ...
+var ASCIINotation = Notation{
+	Dot:       '.',
+	Dash:      '-',
+	LetterGap: " ",
+	WordGap:   " / ",
+}
+
+// UnicodeNotation writes Morse code like ·− −··· / ···
+var UnicodeNotation = Notation{
+	Dot:       '·',
+	Dash:      '−',
+	LetterGap: " ",
+	WordGap:   " / ",
+}
+
+// Write the code for a single character in this notation
+func (n Notation) code(out *strings.Builder, code string) {
+	for _, c := range code {
+		switch c {
+		case '.':
+			out.WriteRune(n.Dot)
+		case '-':
+			out.WriteRune(n.Dash)
+		}
+	}
+}
+
+// Encode s into this notation.
+//
+// Runes with no Morse code are dealt with according to the Unknown
+// policy in opt.
+func (n Notation) Encode(opt *cw.Options, s string) (string, error) {
+	err := Check(opt, s)
+	if err != nil {
+		return "", err
+	}
+	var (
+		out       strings.Builder
+		needGap   = false // set if we need a gap before the next letter
+		needSpace = false // set if we need a word gap before the next letter
+	)
+	for _, r := range s {
+		code := morseCode[normalise(r)]
+		if code == " " {
+			needSpace = needGap
+			continue
+		}
+		codes := []string{code}
+		if code == "" {
+			codes = unknownCodes(opt, r)
+		}
+		for _, code := range codes {
+			if code == " " {
+				needSpace = needGap
+				continue
+			}
+			if needSpace {
+				out.WriteString(n.WordGap)
+			} else if needGap {
+				out.WriteString(n.LetterGap)
+			}
+			n.code(&out, code)
+			needGap = true
+			needSpace = false
+		}
+	}
+	return out.String(), nil
+}
+
+// Encode s into ASCIINotation.
+//
+// Runes with no Morse code are dealt with according to the Unknown
+// policy in opt.
+func Encode(opt *cw.Options, s string) (string, error) {
+	return ASCIINotation.Encode(opt, s)
+}
+
+// Reverse map of morseCode, preferring letters and digits where two
+// runes have the same code
+var decodeMorse = func() map[string]rune {
+	var rs []rune
+	for r := range morseCode {
+		rs = append(rs, r)
+	}
+	isAlnum := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if isAlnum(rs[i]) != isAlnum(rs[j]) {
+			return isAlnum(rs[i])
+		}
+		return rs[i] < rs[j]
+	})
+	decode := make(map[string]rune, len(rs))
+	for _, r := range rs {
+		code := morseCode[r]
+		if _, found := decode[code]; !found && code != " " {
+			decode[code] = r
+		}
+	}
+	return decode
+}()
+
+// Symbols accepted as dits and dahs by Decode
+const (
+	DecodeDots   = ".·•∙⋅"
+	DecodeDashes = "-−–—_"
+)
+
+// DecodedHH is the text Decode writes for the error prosign HH, as
+// written by Encode for unknown runes with cw.UnknownHH
+const DecodedHH = "<HH>"
+
+// Decode Morse code written in any notation back into text.
+//
+// Dits may be written as any of DecodeDots and dahs as any of
+// DecodeDashes. Letters are separated by spaces and words by / or |
+// or new lines.
+//
+// If any codes can't be decoded they are written as � in the returned
+// text and an error listing them is returned.
+func Decode(s string) (string, error) {
+	var (
+		out     strings.Builder
+		letter  strings.Builder
+		unknown []string
+	)
+	endLetter := func() {
+		if letter.Len() == 0 {
+			return
+		}
+		code := letter.String()
+		letter.Reset()
+		if r, found := decodeMorse[code]; found {
+			out.WriteRune(r)
+		} else if code == prosignHH {
+			out.WriteString(DecodedHH)
+		} else {
+			out.WriteRune(unicode.ReplacementChar)
+			unknown = append(unknown, code)
+		}
+	}
+	endWord := func() {
+		endLetter()
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), " ") {
+			out.WriteRune(' ')
+		}
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune(DecodeDots, c):
+			letter.WriteRune('.')
+		case strings.ContainsRune(DecodeDashes, c):
+			letter.WriteRune('-')
+		case c == '/' || c == '|' || c == '\n':
+			endWord()
+		case unicode.IsSpace(c):
+			endLetter()
+		default:
+			return "", fmt.Errorf("unexpected %q in Morse code", c)
+		}
+	}
+	endLetter()
+	text := strings.TrimRight(out.String(), " ")
+	if len(unknown) > 0 {
+		return text, fmt.Errorf("couldn't decode %s", strings.Join(unknown, " "))
+	}
+	return text, nil
+}
@@ -0,0 +1,101 @@
+package cwgenerator
+
+import (
+	"testing"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+func TestEncode(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		unknown cw.UnknownPolicy
+		want    string
+	}{
+		{"", cw.UnknownDrop, ""},
+		{"SOS", cw.UnknownDrop, "... --- ..."},
+		{"cq  de g4abc", cw.UnknownDrop, "-.-. --.- / -.. . / --. ....- .- -... -.-."},
+		{" E ", cw.UnknownDrop, "."},
+		{"E é E", cw.UnknownDrop, ". / ."},
+		{"EéE", cw.UnknownHH, ". ........ ."},
+		{"EéE", cw.UnknownQuestion, ". ..--.. ."},
+		{"EéE", cw.UnknownTransliterate, ". . ."},
+		{"EßE", cw.UnknownTransliterate, ". ... ... ."},
+	} {
+		opt := &cw.Options{Unknown: test.unknown}
+		got, err := Encode(opt, test.in)
+		if err != nil {
+			t.Errorf("Encode(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Encode(%q, %v) = %q, want %q", test.in, test.unknown, got, test.want)
+		}
+	}
+
+	_, err := Encode(&cw.Options{Unknown: cw.UnknownError}, "Eé")
+	if err == nil {
+		t.Error("expected an error encoding an unknown rune with UnknownError")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"... --- ...", "SOS", false},
+		{"-.-. --.- / -.. .", "CQ DE", false},
+		{"-.-. --.- | -.. .\n.", "CQ DE E", false},
+		{"·−  −···", "AB", false},
+		{"•– ∙⋅— ._", "AUA", false},
+		{". ........ .", "E" + DecodedHH + "E", false},
+		{". ........ / .", "E" + DecodedHH + " E", false},
+		{". .-.-.-.- .", "E�E", true},
+		{". x .", "", true},
+	} {
+		got, err := Decode(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Decode(%q) error = %v, want error %v", test.in, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("Decode(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		unknown cw.UnknownPolicy
+		want    string
+	}{
+		{"THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG", cw.UnknownDrop, ""},
+		{"0123456789", cw.UnknownDrop, ""},
+		{"cq de g4abc/p = 599?", cw.UnknownDrop, "CQ DE G4ABC/P = 599?"},
+		{"CAFÉ", cw.UnknownDrop, "CAF"},
+		{"CAFÉ", cw.UnknownTransliterate, "CAFE"},
+		{"CAFÉ", cw.UnknownQuestion, "CAF?"},
+		{"CAFÉ", cw.UnknownHH, "CAF" + DecodedHH},
+	} {
+		if test.want == "" {
+			test.want = test.in
+		}
+		opt := &cw.Options{Unknown: test.unknown}
+		for _, n := range []Notation{ASCIINotation, UnicodeNotation} {
+			code, err := n.Encode(opt, test.in)
+			if err != nil {
+				t.Fatalf("Encode(%q): %v", test.in, err)
+			}
+			got, err := Decode(code)
+			if err != nil {
+				t.Errorf("Decode(%q): %v", code, err)
+			}
+			if got != test.want {
+				t.Errorf("Decode(Encode(%q, %v)) = %q, want %q", test.in, test.unknown, got, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package cwgenerator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ncw/cwtool/cw"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The error prosign HH - eight dits run together
+const prosignHH = "........"
+
+// Runes which don't decompose into something with Morse code
+var transliterations = map[rune]string{
+	'Ø': "O",
+	'Æ': "AE",
+	'Œ': "OE",
+	'ß': "SS",
+	'ẞ': "SS",
+	'Đ': "D",
+	'Ð': "D",
+	'Ł': "L",
+	'Þ': "TH",
+	'Ħ': "H",
+	'“': `"`,
+	'”': `"`,
+	'„': `"`,
+	'«': `"`,
+	'»': `"`,
+	'‘': "'",
+	'’': "'",
+	'‚': "'",
+	'‹': "'",
+	'›': "'",
+	'‐': "-",
+	'‑': "-",
+	'‒': "-",
+	'–': "-",
+	'—': "-",
+	'−': "-",
+	'…': "...",
+	'×': "X",
+	'÷': "/",
+}
+
+// normalise r into the form used to look it up in morseCode
+func normalise(r rune) rune {
+	if unicode.IsSpace(r) {
+		return ' '
+	}
+	return unicode.ToUpper(r)
+}
+
+// Known returns true if r can be sent as Morse code
+func Known(r rune) bool {
+	return morseCode[normalise(r)] != ""
+}
+
+// Transliterate returns the nearest equivalent of r which can be
+// sent as Morse code, eg é as E, ø as O or “ as ".
+//
+// It returns "" if there is no equivalent.
+func Transliterate(r rune) string {
+	r = normalise(r)
+	if morseCode[r] != "" {
+		return string(r)
+	}
+	s, found := transliterations[r]
+	if !found {
+		// Decompose the rune and keep the parts with Morse code
+		// which removes accents and other combining marks
+		s = norm.NFKD.String(string(r))
+	}
+	var out strings.Builder
+	for _, c := range s {
+		c = normalise(c)
+		if morseCode[c] != "" {
+			out.WriteRune(c)
+		}
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		return ""
+	}
+	return out.String()
+}
+
+// Check returns an error if the policy is cw.UnknownError and s
+// contains runes which can't be sent as Morse code
+func Check(opt *cw.Options, s string) error {
+	if opt.Unknown != cw.UnknownError {
+		return nil
+	}
+	var unknown []rune
+	for _, r := range s {
+		if !Known(r) {
+			unknown = append(unknown, r)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("no Morse code for %q in %q", string(unknown), s)
+	}
+	return nil
+}
+
+// unknownCodes returns the Morse codes to send for r which has no
+// Morse code according to the policy in opt, recording what was done
+// in opt.Unknowns
+func unknownCodes(opt *cw.Options, r rune) (codes []string) {
+	switch opt.Unknown {
+	case cw.UnknownHH:
+		opt.Unknowns.Substitute(r, "HH")
+		return []string{prosignHH}
+	case cw.UnknownQuestion:
+		opt.Unknowns.Substitute(r, "?")
+		return []string{morseCode['?']}
+	case cw.UnknownTransliterate:
+		s := Transliterate(r)
+		if s == "" {
+			break
+		}
+		opt.Unknowns.Substitute(r, s)
+		for _, c := range s {
+			codes = append(codes, morseCode[c])
+		}
+		return codes
+	}
+	opt.Unknowns.Drop(r)
+	return nil
+}
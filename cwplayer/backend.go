@@ -0,0 +1,98 @@
+package cwplayer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// BackendEnv is the environment variable used to choose the backend
+// if cw.Options.Backend isn't set
+const BackendEnv = "CWTOOL_BACKEND"
+
+// DefaultBackend is the backend used if none is chosen
+const DefaultBackend = "oto"
+
+// Backend plays the audio
+type Backend interface {
+	// Start playing audio read from src which supplies signed 16 bit
+	// little endian samples with the channels interleaved.
+	//
+	// src always fills the buffer it is passed, with silence if
+	// there is no Morse to play, and is read until Close.
+	//
+	// If the backend stops reading src because of an error it calls
+	// failed so nothing waits for the Morse to be heard.
+	Start(src io.Reader, failed func()) error
+
+	// Latency returns how long a sample takes to be heard after it
+	// has been read
+	Latency() time.Duration
+
+	// Err returns any error playing the audio
+	Err() error
+
+	// Close stops playing the audio
+	Close() error
+}
+
+// newBackendFunc makes a backend from the options and the argument
+// given after a ":" in the backend name
+type newBackendFunc func(opt *cw.Options, arg string) (Backend, error)
+
+// backends maps the name of each backend onto its constructor
+var backends = map[string]newBackendFunc{
+	"oto":     newOto,
+	"null":    newNull,
+	"capture": newCapture,
+}
+
+// Backends returns the names of the backends
+func Backends() (names []string) {
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newBackend makes the backend given by opt.Backend, or the
+// BackendEnv environment variable if that isn't set.
+//
+// This is a name from Backends, optionally followed by ":" and an
+// argument, eg "capture:out.raw".
+func newBackend(opt *cw.Options) (Backend, error) {
+	spec := opt.Backend
+	if spec == "" {
+		spec = os.Getenv(BackendEnv)
+	}
+	if spec == "" {
+		spec = DefaultBackend
+	}
+	name, arg, _ := strings.Cut(spec, ":")
+	newFn, found := backends[name]
+	if !found {
+		return nil, fmt.Errorf("unknown audio backend %q: must be one of %s", name, strings.Join(Backends(), ", "))
+	}
+	if opt.Device != "" && name != "oto" {
+		return nil, fmt.Errorf("can't choose the device with the %s audio backend", name)
+	}
+	return newFn(opt, arg)
+}
+
+// bytesPerSecond returns the data rate of the audio
+func bytesPerSecond(opt *cw.Options) int {
+	return opt.SampleRate * opt.Channels * opt.BitDepthInBytes
+}
+
+// bytesFor returns the number of bytes of whole samples of audio
+// which play for d
+func bytesFor(opt *cw.Options, d time.Duration) int {
+	frame := opt.Channels * opt.BitDepthInBytes
+	return int(d.Seconds()*float64(bytesPerSecond(opt))) / frame * frame
+}
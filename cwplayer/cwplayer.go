@@ -1,72 +1,260 @@
 package cwplayer
 
 import (
+	"io"
+	"sync"
 	"time"
 
-	"github.com/hajimehoshi/oto/v2"
 	"github.com/ncw/cwtool/cw"
 	"github.com/ncw/cwtool/cwgenerator"
 )
 
 // Player contains state for the Morse generation
 type Player struct {
-	generator *cwgenerator.Generator
-	opt       *cw.Options
-	context   *oto.Context
-	player    oto.Player
+	generator      *cwgenerator.Generator
+	opt            *cw.Options
+	backend        Backend
+	bytesPerSecond float64
+
+	mu        sync.Mutex
+	queued    uint64        // incremented each time Morse is added
+	scheduled uint64        // value of queued when completion was last scheduled
+	played    uint64        // value of queued when the Morse was last all played
+	done      chan struct{} // closed when everything queued has been played
+	end       time.Time     // when the audio read so far will have been sent to the device
+	sent      time.Time     // when the last of the Morse read will have been sent to the device
+	paused    bool          // set if the Morse is paused
+	failed    bool          // set if the backend has stopped with an error
+	wpm       float64       // current speed
 }
 
 func New(opt *cw.Options) (*Player, error) {
-	context, ready, err := oto.NewContext(opt.SampleRate, opt.Channels, opt.BitDepthInBytes)
+	backend, err := newBackend(opt)
 	if err != nil {
 		return nil, err
 	}
-	<-ready
-	generator := cwgenerator.New(opt)
+
+	// The generator returns short reads rather than EOF when it runs
+	// out of Morse so the player never stops
+	genOpt := *opt
+	genOpt.Continuous = true
 	p := &Player{
-		generator: generator,
-		context:   context,
-		player:    context.NewPlayer(generator),
+		generator:      cwgenerator.New(&genOpt),
+		opt:            opt,
+		backend:        backend,
+		bytesPerSecond: float64(bytesPerSecond(opt)),
+		done:           make(chan struct{}),
+		wpm:            opt.WPM,
+	}
+	close(p.done) // nothing to play yet
+	err = backend.Start(p, p.fail)
+	if err != nil {
+		_ = backend.Close()
+		return nil, err
 	}
-	p.player.Reset()
 	return p, nil
 }
 
-// kick the player into action
-func (p *Player) kick() {
-	// There is a race condition here if the player stops playing
-	// after the test, however we kick again in Sync which should
-	// pick it up.
-	if !p.player.IsPlaying() {
-		p.player.Reset()
+// duration returns how long n bytes of audio play for
+func (p *Player) duration(n int) time.Duration {
+	return time.Duration(float64(n) / p.bytesPerSecond * float64(time.Second))
+}
+
+// Read is called by the audio device to read the audio.
+//
+// When the generator runs out of Morse the rest of buf is filled with
+// silence so the device keeps playing, and a timer is set to go off
+// when the last of the Morse will have been heard.
+func (p *Player) Read(buf []byte) (int, error) {
+	p.mu.Lock()
+	queued := p.queued
+	p.mu.Unlock()
+
+	n, err := p.generator.Read(buf)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	drained := n < len(buf)
+	for i := range buf[n:] {
+		buf[n+i] = 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.end.Before(now) {
+		// The device has caught up with us
+		p.end = now
+	}
+	if n > 0 {
+		p.sent = p.end.Add(p.duration(n))
+	}
+	p.end = p.end.Add(p.duration(len(buf)))
+	if drained && !p.paused && queued > p.scheduled {
+		p.scheduled = queued
+		time.AfterFunc(p.sent.Add(p.backend.Latency()).Sub(now), func() {
+			p.finish(queued)
+		})
+	}
+	return len(buf), nil
+}
+
+// finish marks the Morse up to queued as played
+func (p *Player) finish(queued uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		// Resume will schedule this again
+		return
+	}
+	if queued > p.played {
+		p.played = queued
+	}
+	if p.played == p.queued {
+		select {
+		case <-p.done:
+		default:
+			close(p.done)
+		}
+	}
+}
+
+// fail is called by the backend if it stops because of an error.
+//
+// Nothing more will be heard so Done is closed for good.
+func (p *Player) fail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failed = true
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+// queue notes that Morse has been added to the generator
+func (p *Player) queue() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued++
+	if p.failed {
+		return
+	}
+	select {
+	case <-p.done:
+		p.done = make(chan struct{})
+	default:
 	}
-	p.player.Play()
 }
 
 // Rune adds r to the output
 func (p *Player) Rune(r rune) {
 	p.generator.Rune(r)
-	p.kick()
+	p.queue()
 }
 
 // String adds s to the output
 func (p *Player) String(s string) {
 	p.generator.String(s)
-	p.kick()
+	p.queue()
 }
 
-// Sync by waiting for all the Morse to be played
-func (p *Player) Sync() {
-	p.kick()
-	for p.player.IsPlaying() {
-		time.Sleep(time.Millisecond)
+// Silence adds d of silence to the output
+func (p *Player) Silence(d time.Duration) {
+	p.generator.Silence(d)
+	p.queue()
+}
+
+// Pause the Morse at the end of the current dit.
+//
+// The device carries on playing silence and Done won't be closed until
+// after Resume.
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	p.generator.Pause(true)
+}
+
+// Resume the Morse after Pause
+func (p *Player) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.generator.Pause(false)
+	// Completion may have been ignored while paused
+	p.scheduled = p.played
+}
+
+// Paused returns whether the Morse is paused
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Clear removes any Morse which hasn't been played yet
+func (p *Player) Clear() {
+	p.generator.Clear()
+}
+
+// Reconfigure changes the speed and pitch of the Morse from the next
+// dit played
+func (p *Player) Reconfigure(wpm, frequency float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wpm = wpm
+	p.generator.Reconfigure(wpm, frequency)
+}
+
+// Position returns how many dits of Morse have been heard and how
+// many have been added so far, allowing for the latency of the audio
+// device.
+//
+// Clear removes the dits which weren't played from the count added.
+func (p *Player) Position() (heard, added int) {
+	read, added := p.generator.Position()
+	p.mu.Lock()
+	unheard := time.Until(p.sent.Add(p.backend.Latency()))
+	dit := cwgenerator.DitDuration(p.wpm)
+	p.mu.Unlock()
+	heard = read
+	if unheard > 0 {
+		heard -= int(unheard / dit)
+	}
+	if heard < 0 {
+		heard = 0
 	}
+	return heard, added
+}
+
+// Done returns a channel which is closed when all the Morse added so
+// far has been heard, allowing for the latency of the audio device,
+// or the backend has failed.
+//
+// Adding more Morse makes a new channel so Done should be called
+// again afterwards.
+func (p *Player) Done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// Sync by waiting for all the Morse to be played
+func (p *Player) Sync() error {
+	<-p.Done()
+	return p.backend.Err()
 }
 
 // Close the output
 func (p *Player) Close() error {
-	return nil
+	return p.backend.Close()
 }
 
-// Check interface
-var _ cw.CW = (*Player)(nil)
+// Check interfaces
+var (
+	_ cw.CW       = (*Player)(nil)
+	_ cw.Silencer = (*Player)(nil)
+	_ cw.Live     = (*Player)(nil)
+)
@@ -0,0 +1,232 @@
+package cwplayer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// testOptions returns options for playing with backend
+func testOptions(backend string) *cw.Options {
+	return &cw.Options{
+		WPM:             60,
+		Frequency:       600,
+		SampleRate:      8000,
+		Channels:        1,
+		BitDepthInBytes: 2,
+		MaxSampleValue:  32767,
+		Backend:         backend,
+	}
+}
+
+// isDone returns whether done is closed
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// generate returns the audio the generator makes for text
+func generate(t *testing.T, opt *cw.Options, text string) []byte {
+	t.Helper()
+	g := cwgenerator.New(opt)
+	g.String(text)
+	audio, err := io.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return audio
+}
+
+// playTime returns how long audio plays for
+func playTime(opt *cw.Options, audio []byte) time.Duration {
+	return time.Duration(float64(len(audio)) / float64(bytesPerSecond(opt)) * float64(time.Second))
+}
+
+func TestSyncAndDone(t *testing.T) {
+	opt := testOptions("null")
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	if !isDone(p.Done()) {
+		t.Error("Done not closed before anything was played")
+	}
+	want := playTime(opt, generate(t, opt, "PARIS"))
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		p.String("PARIS")
+		done := p.Done()
+		if isDone(done) {
+			t.Fatal("Done closed before the Morse was played")
+		}
+		err = p.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if !isDone(done) || !isDone(p.Done()) {
+			t.Error("Done not closed after Sync")
+		}
+		if elapsed < want-2*nullPeriod || elapsed > want+500*time.Millisecond {
+			t.Errorf("Sync took %v, want about %v", elapsed, want)
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	opt := testOptions("null")
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	want := playTime(opt, generate(t, opt, "E"))
+	p.Pause()
+	p.String("E")
+	time.Sleep(want + 100*time.Millisecond)
+	if isDone(p.Done()) {
+		t.Fatal("Done closed while paused")
+	}
+	p.Resume()
+	select {
+	case <-p.Done():
+	case <-time.After(want + time.Second):
+		t.Fatal("Done not closed after Resume")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "out.raw")
+	opt := testOptions("capture:" + file)
+	p, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.String("CQ")
+	err = p.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("capture file visible before Close: %v", err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The capture has silence either side of the Morse
+	trim := func(b []byte) []byte {
+		return bytes.Trim(b, "\x00")
+	}
+	want := generate(t, opt, "CQ")
+	if !bytes.Equal(trim(got), trim(want)) {
+		t.Errorf("captured %d bytes of Morse, want %d", len(trim(got)), len(trim(want)))
+	}
+
+	// It won't overwrite the capture without Force
+	_, err = New(opt)
+	if err == nil {
+		t.Fatal("expected an error overwriting the capture file")
+	}
+	opt.Force = true
+	p, err = New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the capture file, got %d files", len(entries))
+	}
+}
+
+// failingFile is a cwfile.File which fails after n bytes
+type failingFile struct {
+	n       int
+	aborted bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		return 0, errors.New("disk full")
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func (f *failingFile) Commit() error {
+	return errors.New("committed a failed capture")
+}
+
+func (f *failingFile) Abort() error {
+	f.aborted = true
+	return nil
+}
+
+func TestCaptureFails(t *testing.T) {
+	out := &failingFile{n: 1000}
+	backends["failing"] = func(opt *cw.Options, arg string) (Backend, error) {
+		return &nullBackend{
+			opt:  opt,
+			out:  out,
+			stop: make(chan struct{}),
+		}, nil
+	}
+	defer delete(backends, "failing")
+	p, err := New(testOptions("failing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.String("PARIS PARIS")
+	synced := make(chan error, 1)
+	go func() {
+		synced <- p.Sync()
+	}()
+	select {
+	case err = <-synced:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Sync didn't return after the capture failed")
+	}
+	if err == nil {
+		t.Error("Sync didn't return the capture error")
+	}
+	// Adding more Morse doesn't wait for it to be heard
+	p.String("CQ")
+	if !isDone(p.Done()) {
+		t.Error("Done not closed after the capture failed")
+	}
+	err = p.Close()
+	if err == nil {
+		t.Error("Close didn't return the capture error")
+	}
+	if !out.aborted {
+		t.Error("failed capture not aborted")
+	}
+}
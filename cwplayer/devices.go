@@ -0,0 +1,38 @@
+package cwplayer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultDevice is the name of the system default audio output
+const DefaultDevice = "default"
+
+// Device describes an audio output device
+type Device struct {
+	Name        string // name to pass as cw.Options.Device
+	Description string // human readable description
+	card        string // card index for the audio system
+	device      string // device index on the card
+}
+
+// findDevice finds the device called name, which may also be the
+// name of its card to use the first device on the card.
+func findDevice(name string) (Device, error) {
+	devices, err := Devices()
+	if err != nil {
+		return Device{}, err
+	}
+	for _, match := range []func(d Device) bool{
+		func(d Device) bool { return strings.EqualFold(d.Name, name) },
+		func(d Device) bool { return strings.EqualFold(strings.Split(d.Name, ",")[0], name) },
+		func(d Device) bool { return d.card == name },
+	} {
+		for _, d := range devices {
+			if match(d) {
+				return d, nil
+			}
+		}
+	}
+	return Device{}, fmt.Errorf("audio output device %q not found - use \"cwtool devices\" to list them", name)
+}
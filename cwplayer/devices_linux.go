@@ -0,0 +1,123 @@
+//go:build linux
+// +build linux
+
+package cwplayer
+
+// Audio devices on Linux
+//
+// oto always opens the ALSA "default" device, so a device is chosen
+// by setting the environment variables which the default ALSA
+// configuration uses to pick the card and device for "default". This
+// has no effect if "default" has been redirected, eg to PulseAudio.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lines in /proc/asound/cards like " 1 [Device         ]: USB-Audio - USB Audio Device"
+var cardRe = regexp.MustCompile(`^\s*(\d+)\s+\[(.*?)\s*\]:\s*(.*?)\s+-\s+(.*)$`)
+
+// Lines in /proc/asound/pcm like "01-00: USB Audio : USB Audio : playback 1 : capture 1"
+var pcmRe = regexp.MustCompile(`^(\d+)-(\d+):\s*(.*?)\s*:`)
+
+// parseCards reads /proc/asound/cards returning the card IDs and
+// names by index
+func parseCards(in io.Reader) (ids, names map[string]string, err error) {
+	ids = map[string]string{}
+	names = map[string]string{}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		match := cardRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		ids[match[1]] = match[2]
+		names[match[1]] = match[4]
+	}
+	return ids, names, scanner.Err()
+}
+
+// parsePCM reads /proc/asound/pcm returning the playback devices
+func parsePCM(in io.Reader, ids, names map[string]string) (devices []Device, err error) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := pcmRe.FindStringSubmatch(line)
+		if match == nil || !strings.Contains(line, ": playback ") {
+			continue
+		}
+		card, _ := strconv.Atoi(match[1])
+		device, _ := strconv.Atoi(match[2])
+		index := strconv.Itoa(card)
+		id := ids[index]
+		if id == "" {
+			id = index
+		}
+		devices = append(devices, Device{
+			Name:        fmt.Sprintf("%s,%d", id, device),
+			Description: names[index] + ": " + match[3],
+			card:        index,
+			device:      strconv.Itoa(device),
+		})
+	}
+	return devices, scanner.Err()
+}
+
+// Devices returns the audio output devices with the system default
+// first
+func Devices() ([]Device, error) {
+	devices := []Device{{
+		Name:        DefaultDevice,
+		Description: "System default audio output",
+	}}
+	cards, err := os.Open("/proc/asound/cards")
+	if os.IsNotExist(err) {
+		// No sound cards or no ALSA
+		return devices, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sound cards: %w", err)
+	}
+	defer func() {
+		_ = cards.Close()
+	}()
+	ids, names, err := parseCards(cards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sound cards: %w", err)
+	}
+	pcm, err := os.Open("/proc/asound/pcm")
+	if os.IsNotExist(err) {
+		return devices, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sound devices: %w", err)
+	}
+	defer func() {
+		_ = pcm.Close()
+	}()
+	playback, err := parsePCM(pcm, ids, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sound devices: %w", err)
+	}
+	return append(devices, playback...), nil
+}
+
+// selectDevice makes name the device oto will open
+func selectDevice(name string) error {
+	d, err := findDevice(name)
+	if err != nil {
+		return err
+	}
+	if d.Name == DefaultDevice {
+		return nil
+	}
+	err = os.Setenv("ALSA_CARD", d.card)
+	if err != nil {
+		return err
+	}
+	return os.Setenv("ALSA_PCM_DEVICE", d.device)
+}
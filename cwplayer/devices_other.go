@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package cwplayer
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Devices returns the audio output devices.
+//
+// Only the system default is supported on this platform.
+func Devices() ([]Device, error) {
+	return []Device{{
+		Name:        DefaultDevice,
+		Description: "System default audio output",
+	}}, nil
+}
+
+// selectDevice makes name the device oto will open
+func selectDevice(name string) error {
+	d, err := findDevice(name)
+	if err != nil {
+		return err
+	}
+	if d.Name != DefaultDevice {
+		return fmt.Errorf("choosing the audio output device isn't supported on %s", runtime.GOOS)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package cwplayer
+
+// Simulated backends
+//
+// These read the audio in real time as an audio device would, but
+// throw it away or record it, so the interactive commands can be run
+// on machines without audio and in scripted tests.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwfile"
+)
+
+// nullPeriod is how often the simulated backends read audio
+const nullPeriod = 10 * time.Millisecond
+
+// nullBackend reads the audio in real time writing it to out if set
+type nullBackend struct {
+	opt  *cw.Options
+	out  cwfile.File // write the audio here if set
+	stop chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	err  error
+}
+
+// newNull makes a backend which discards the audio
+func newNull(opt *cw.Options, arg string) (Backend, error) {
+	return &nullBackend{
+		opt:  opt,
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// newCapture makes a backend which records the audio to the file
+// given by arg as raw PCM.
+//
+// The file is written like an output file so it won't overwrite an
+// existing file unless opt.Force is set.
+func newCapture(opt *cw.Options, arg string) (Backend, error) {
+	if arg == "" {
+		return nil, errors.New("capture audio backend needs a file, eg capture:out.raw")
+	}
+	out, err := cwfile.Create(arg, opt.Force)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	return &nullBackend{
+		opt:  opt,
+		out:  out,
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Start reading audio from src
+func (b *nullBackend) Start(src io.Reader, failed func()) error {
+	b.wg.Add(1)
+	go b.run(src, failed)
+	return nil
+}
+
+// Read the audio from src every nullPeriod until stopped, calling
+// failed if there is an error
+func (b *nullBackend) run(src io.Reader, failed func()) {
+	defer b.wg.Done()
+	buf := make([]byte, bytesFor(b.opt, nullPeriod))
+	ticker := time.NewTicker(nullPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+		_, err := io.ReadFull(src, buf)
+		if err == nil && b.out != nil {
+			_, err = b.out.Write(buf)
+		}
+		if err != nil {
+			b.mu.Lock()
+			b.err = err
+			b.mu.Unlock()
+			failed()
+			return
+		}
+	}
+}
+
+// Latency returns how long a sample takes to be heard after it has
+// been read
+func (b *nullBackend) Latency() time.Duration {
+	return 0
+}
+
+// Err returns any error playing the audio
+func (b *nullBackend) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Close stops reading the audio, committing the capture file if there
+// were no errors
+func (b *nullBackend) Close() error {
+	close(b.stop)
+	b.wg.Wait()
+	err := b.Err()
+	if b.out == nil {
+		return err
+	}
+	if err != nil {
+		return errors.Join(err, b.out.Abort())
+	}
+	return b.out.Commit()
+}
@@ -0,0 +1,86 @@
+package cwplayer
+
+import (
+	"io"
+	"time"
+
+	"github.com/hajimehoshi/oto/v2"
+	"github.com/ncw/cwtool/cw"
+)
+
+const (
+	// otoLatency is the size of the buffer in the audio device,
+	// which is how long a sample takes to be heard once it has been
+	// sent
+	otoLatency = 100 * time.Millisecond
+
+	// otoLookahead is how much audio the player reads ahead of the
+	// audio device. This must be at least the period of the device,
+	// which is half the latency, or there will be gaps in the audio.
+	otoLookahead = otoLatency
+)
+
+// otoBackend plays audio on the speaker with oto
+type otoBackend struct {
+	opt     *cw.Options
+	context *oto.Context
+	player  oto.Player
+}
+
+func newOto(opt *cw.Options, arg string) (Backend, error) {
+	if opt.Device != "" {
+		err := selectDevice(opt.Device)
+		if err != nil {
+			return nil, err
+		}
+	}
+	context, ready, err := oto.NewContextWithOptions(&oto.NewContextOptions{
+		SampleRate:   opt.SampleRate,
+		ChannelCount: opt.Channels,
+		Format:       oto.FormatSignedInt16LE, // as made by the generator
+		BufferSize:   otoLatency,
+	})
+	if err != nil {
+		return nil, err
+	}
+	<-ready
+	return &otoBackend{
+		opt:     opt,
+		context: context,
+	}, nil
+}
+
+// Start playing audio from src.
+//
+// oto reports its errors through Err so failed isn't used.
+func (b *otoBackend) Start(src io.Reader, failed func()) error {
+	b.player = b.context.NewPlayer(src)
+	if s, ok := b.player.(oto.BufferSizeSetter); ok {
+		s.SetBufferSize(bytesFor(b.opt, otoLookahead))
+	}
+	b.player.Play()
+	return b.Err()
+}
+
+// Latency returns how long a sample takes to be heard after it has
+// been read
+func (b *otoBackend) Latency() time.Duration {
+	return otoLatency
+}
+
+// Err returns any error playing the audio
+func (b *otoBackend) Err() error {
+	err := b.context.Err()
+	if err == nil && b.player != nil {
+		err = b.player.Err()
+	}
+	return err
+}
+
+// Close stops playing the audio
+func (b *otoBackend) Close() error {
+	if b.player == nil {
+		return nil
+	}
+	return b.player.Close()
+}
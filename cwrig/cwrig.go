@@ -0,0 +1,320 @@
+// Package cwrig sends Morse with a radio controlled by Hamlib's
+// rigctld
+package cwrig
+
+// The radio sends the Morse itself from text passed with the
+// send_morse command over the rigctld TCP protocol. rigctld replies
+// once the radio has accepted the text, not when it has sent it, so
+// how long the Morse takes is worked out from the speed.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// Scheme is the prefix of an output file naming a rigctld to connect
+// to, eg "rigctld:localhost:4532"
+const Scheme = "rigctld:"
+
+const (
+	defaultHost = "localhost"
+	defaultPort = "4532"
+	timeout     = 10 * time.Second // how long to wait for rigctld to reply
+
+	errInvalid        = -1  // RIG_EINVAL - returned by old versions for unknown commands
+	errNotImplemented = -4  // RIG_ENIMPL
+	errNotAvailable   = -11 // RIG_ENAVAIL
+)
+
+// Rig sends the Morse with a radio via rigctld
+type Rig struct {
+	opt       *cw.Options
+	conn      net.Conn
+	in        *bufio.Reader
+	generator *cwgenerator.Generator // for working out how long the Morse takes
+	dit       time.Duration
+
+	mu      sync.Mutex
+	pending strings.Builder // text not sent yet
+	end     time.Time       // when the Morse sent so far will have finished
+	done    chan struct{}   // closed when the Morse sent so far has finished
+	noWait  bool            // set if rigctld doesn't support wait_morse
+	err     error           // first error talking to rigctld
+}
+
+// New connects to the rigctld named by opt.OutputFile which should
+// start with Scheme and sets the keyer speed to opt.WPM.
+//
+// The address is host:port which defaults to localhost:4532.
+func New(opt *cw.Options) (*Rig, error) {
+	if opt.Farnsworth > 0 {
+		return nil, errors.New("--farnsworth can't be used with --out " + Scheme)
+	}
+	if opt.Unknown == cw.UnknownHH {
+		// The radio is sent text which can't say HH
+		return nil, errors.New("--unknown hh can't be used with --out " + Scheme)
+	}
+	addr := strings.TrimPrefix(opt.OutputFile, Scheme)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port so it is all host
+		host, port = addr, ""
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	addr = net.JoinHostPort(host, port)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rigctld: %w", err)
+	}
+	timingOpt := *opt
+	timingOpt.Unknowns = nil
+	r := &Rig{
+		opt:       opt,
+		conn:      conn,
+		in:        bufio.NewReader(conn),
+		generator: cwgenerator.New(&timingOpt),
+		dit:       cwgenerator.DitDuration(opt.WPM),
+		done:      make(chan struct{}),
+	}
+	close(r.done) // nothing sent yet
+	wpm := int(math.Round(opt.WPM))
+	err = r.command("set_level", "KEYSPD", strconv.Itoa(wpm))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if opt.Debug {
+		log.Printf("Connected to rigctld at %s with keyer speed %d WPM", addr, wpm)
+	}
+	return r, nil
+}
+
+// rigError is an error code returned by rigctld
+type rigError struct {
+	name string // name of the command
+	code int    // negative Hamlib error code
+}
+
+// Error satisfies the error interface
+func (e *rigError) Error() string {
+	return fmt.Sprintf("rigctld %s failed with error %d", e.name, e.code)
+}
+
+// command sends the named command with args to rigctld and reads the
+// reply which is "RPRT 0" for success, call with the lock held or
+// before use
+func (r *Rig) command(name string, args ...string) error {
+	line := strings.Join(append([]string{`\` + name}, args...), " ")
+	if r.opt.Debug {
+		log.Printf("rigctld: %q", line)
+	}
+	err := r.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.Write([]byte(line + "\n"))
+	if err != nil {
+		return fmt.Errorf("failed to send %s to rigctld: %w", name, err)
+	}
+	reply, err := r.in.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read reply to %s from rigctld: %w", name, err)
+	}
+	reply = strings.TrimSpace(reply)
+	code, err := strconv.Atoi(strings.TrimPrefix(reply, "RPRT "))
+	if err != nil || !strings.HasPrefix(reply, "RPRT ") {
+		return fmt.Errorf("unexpected reply to %s from rigctld: %q", name, reply)
+	}
+	if code != 0 {
+		return &rigError{name: name, code: code}
+	}
+	return nil
+}
+
+// duration returns how long the radio takes to send text
+func (r *Rig) duration(text string) time.Duration {
+	r.generator.String(text)
+	_, dits := r.generator.Position()
+	r.generator.Clear()
+	return time.Duration(dits) * r.dit
+}
+
+// send text to the radio, call with the lock held
+func (r *Rig) _send(text string) {
+	if text == "" || r.err != nil {
+		return
+	}
+	err := r.command("send_morse", text)
+	if err != nil {
+		r.err = err
+		return
+	}
+	now := time.Now()
+	if r.end.Before(now) {
+		r.end = now
+	}
+	r.end = r.end.Add(r.duration(text))
+	select {
+	case <-r.done:
+		r.done = make(chan struct{})
+	default:
+	}
+	done, end := r.done, r.end
+	time.AfterFunc(time.Until(end), func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.done == done && !r.end.After(end) {
+			close(done)
+		}
+	})
+}
+
+// flush sends the complete words. If all is set then send everything.
+//
+// Spaces after the words sent are kept back as a single space at the
+// start of the next text sent so the words aren't run together when
+// the radio is sent them in pieces, and rigctld is never sent just
+// spaces.
+//
+// Call with the lock held.
+func (r *Rig) _flush(all bool) {
+	text := r.pending.String()
+	i := len(text)
+	if !all {
+		i = strings.LastIndexByte(text, ' ') + 1
+	}
+	send := strings.TrimRight(text[:i], " ")
+	rest := text[len(send):]
+	if strings.HasPrefix(rest, " ") {
+		rest = " " + strings.TrimLeft(rest, " ")
+	}
+	if strings.TrimSpace(send) != "" {
+		r._send(send)
+	}
+	r.pending.Reset()
+	r.pending.WriteString(rest)
+}
+
+// add s to the text to send
+func (r *Rig) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending.WriteString(s)
+	r._flush(false)
+}
+
+// encode r into the text to send to the radio
+//
+// Runes which the radio can't send are dealt with according to the
+// Unknown policy in the options. With cw.UnknownError nothing more is
+// sent and the error is returned by Sync and Close. cw.UnknownHH is
+// refused by New.
+func (r *Rig) encode(c rune) string {
+	if c == ' ' || c == '\t' || c == '\n' {
+		return " "
+	}
+	if cwgenerator.Known(c) {
+		return strings.ToUpper(string(c))
+	}
+	s := ""
+	switch r.opt.Unknown {
+	case cw.UnknownError:
+		r.mu.Lock()
+		if r.err == nil {
+			r.err = cwgenerator.Check(r.opt, string(c))
+		}
+		r.mu.Unlock()
+		return ""
+	case cw.UnknownQuestion:
+		s = "?"
+	case cw.UnknownTransliterate:
+		s = cwgenerator.Transliterate(c)
+	}
+	if s == "" {
+		r.opt.Unknowns.Drop(c)
+	} else {
+		r.opt.Unknowns.Substitute(c, s)
+	}
+	return s
+}
+
+// Rune adds r to the output
+func (r *Rig) Rune(c rune) {
+	r.add(r.encode(c))
+}
+
+// String adds s to the output
+func (r *Rig) String(s string) {
+	var out strings.Builder
+	for _, c := range s {
+		out.WriteString(r.encode(c))
+	}
+	r.add(out.String())
+}
+
+// Done returns a channel which is closed when the radio should have
+// sent all the Morse added so far.
+//
+// Adding more Morse makes a new channel so Done should be called
+// again afterwards.
+func (r *Rig) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r._flush(true)
+	return r.done
+}
+
+// Sync by waiting for the radio to send all the Morse.
+//
+// If rigctld supports wait_morse then it is used to wait for the
+// radio to finish too.
+func (r *Rig) Sync() error {
+	<-r.Done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.noWait {
+		return r.err
+	}
+	err := r.command("wait_morse")
+	var rigErr *rigError
+	if errors.As(err, &rigErr) && (rigErr.code == errInvalid || rigErr.code == errNotImplemented || rigErr.code == errNotAvailable) {
+		r.noWait = true
+		err = nil
+	}
+	if err != nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Close the connection to rigctld after the Morse has been sent
+func (r *Rig) Close() error {
+	err := r.Sync()
+	closeErr := r.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Check interfaces
+var (
+	_ cw.CW   = (*Rig)(nil)
+	_ cw.Live = (*Rig)(nil)
+)
@@ -0,0 +1,213 @@
+package cwrig
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+// fakeRigctld answers rigctld commands on a local port
+type fakeRigctld struct {
+	listener net.Listener
+	waitCode int // reply code for wait_morse
+	finished chan struct{}
+
+	mu    sync.Mutex
+	lines []string // commands received
+}
+
+// newFakeRigctld starts a fake rigctld replying to wait_morse with
+// waitCode
+func newFakeRigctld(t *testing.T, waitCode int) *fakeRigctld {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRigctld{
+		listener: listener,
+		waitCode: waitCode,
+		finished: make(chan struct{}),
+	}
+	go f.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return f
+}
+
+// serve one connection until it is closed
+func (f *fakeRigctld) serve() {
+	defer close(f.finished)
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	in := bufio.NewReader(conn)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		f.mu.Lock()
+		f.lines = append(f.lines, line)
+		f.mu.Unlock()
+		code := 0
+		if line == `\wait_morse` {
+			code = f.waitCode
+		}
+		_, err = fmt.Fprintf(conn, "RPRT %d\n", code)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// commands returns the commands received once the connection has
+// been closed
+func (f *fakeRigctld) commands() []string {
+	<-f.finished
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lines
+}
+
+// options returns options to connect to f
+func (f *fakeRigctld) options() *cw.Options {
+	return &cw.Options{
+		WPM:        60, // 20ms dits
+		OutputFile: Scheme + f.listener.Addr().String(),
+	}
+}
+
+// checkCommands checks the commands received are want
+func checkCommands(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got commands\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRig(t *testing.T) {
+	f := newFakeRigctld(t, 0)
+	r, err := New(f.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.String("CQ TEST")
+	r.Rune(' ')
+	err = r.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.String("DE  EI")
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, f.commands(), []string{
+		`\set_level KEYSPD 60`,
+		`\send_morse CQ`,
+		`\send_morse  TEST`,
+		`\wait_morse`,
+		`\send_morse  DE`,
+		`\send_morse  EI`,
+		`\wait_morse`,
+	})
+}
+
+func TestRigDone(t *testing.T) {
+	f := newFakeRigctld(t, 0)
+	r, err := New(f.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	r.String("PARIS")
+	done := r.Done()
+	select {
+	case <-done:
+		t.Error("Done closed before the Morse could have been sent")
+	default:
+	}
+	err = r.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PARIS is 43 dits without the word space after it
+	want := 43 * 20 * time.Millisecond
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("Sync returned after %v, want at least %v", elapsed, want)
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("Done not closed after Sync")
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRigNoWaitMorse(t *testing.T) {
+	f := newFakeRigctld(t, errNotImplemented)
+	r, err := New(f.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.String("E")
+	err = r.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, f.commands(), []string{
+		`\set_level KEYSPD 60`,
+		`\send_morse E`,
+		`\wait_morse`,
+	})
+}
+
+func TestRigUnknown(t *testing.T) {
+	f := newFakeRigctld(t, 0)
+	opt := f.options()
+	opt.Unknown = cw.UnknownError
+	r, err := New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.String("CQ ")
+	r.String("ΩMEGA ")
+	r.String("TEST ")
+	err = r.Sync()
+	if err == nil || !strings.Contains(err.Error(), "Ω") {
+		t.Errorf("expected an error about Ω, got %v", err)
+	}
+	err = r.Close()
+	if err == nil {
+		t.Error("expected Close to return the error")
+	}
+	checkCommands(t, f.commands(), []string{
+		`\set_level KEYSPD 60`,
+		`\send_morse CQ`,
+	})
+
+	opt.Unknown = cw.UnknownHH
+	_, err = New(opt)
+	if err == nil {
+		t.Error("expected an error with --unknown hh")
+	}
+}
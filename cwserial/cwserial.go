@@ -0,0 +1,227 @@
+// Package cwserial keys a transmitter with the DTR or RTS line of a
+// serial port
+package cwserial
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// Scheme is the prefix of an output file naming a serial port, eg
+// "serial:/dev/ttyUSB0"
+const Scheme = "serial:"
+
+// DefaultKeyLine is the line used to key the Morse if not set
+const DefaultKeyLine = "dtr"
+
+// Lines returns the names of the serial port lines which can be used
+func Lines() (names []string) {
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Look up the line called name
+func parseLine(name string) (int, error) {
+	line, found := lines[strings.ToLower(name)]
+	if !found {
+		return 0, fmt.Errorf("unknown serial port line %q: must be one of %s", name, strings.Join(Lines(), ", "))
+	}
+	return line, nil
+}
+
+// lineSetter sets the control lines of a serial port
+type lineSetter interface {
+	// set line on or off
+	set(line int, on bool) error
+
+	// close the port
+	close() error
+}
+
+// Keyer keys the Morse on a line of a serial port in real time
+type Keyer struct {
+	generator *cwgenerator.Generator
+	clock     *cwgenerator.Clock
+	opt       *cw.Options
+	port      lineSetter
+	key       int  // line to key the Morse with
+	ptt       int  // line to use for PTT or 0 for none
+	keyed     bool // whether the key line is on
+
+	mu  sync.Mutex
+	err error // first error setting the lines
+}
+
+// New opens the serial port named by opt.OutputFile which should
+// start with Scheme and starts keying the Morse added on it
+func New(opt *cw.Options) (*Keyer, error) {
+	path := strings.TrimPrefix(opt.OutputFile, Scheme)
+	if path == "" {
+		return nil, fmt.Errorf("need a serial port after %q", Scheme)
+	}
+	keyLine := opt.KeyLine
+	if keyLine == "" {
+		keyLine = DefaultKeyLine
+	}
+	key, err := parseLine(keyLine)
+	if err != nil {
+		return nil, err
+	}
+	var ptt int
+	if opt.PTTLine != "" {
+		ptt, err = parseLine(opt.PTTLine)
+		if err != nil {
+			return nil, err
+		}
+		if ptt == key {
+			return nil, errors.New("can't use the same serial port line for keying and PTT")
+		}
+	}
+	p, err := openPort(path)
+	if err != nil {
+		return nil, err
+	}
+	err = p.checkLines()
+	if err != nil {
+		_ = p.close()
+		return nil, err
+	}
+	return newKeyer(opt, p, key, ptt)
+}
+
+// newKeyer starts keying the Morse added on the key line of p, using
+// the ptt line for PTT if it isn't 0
+func newKeyer(opt *cw.Options, p lineSetter, key, ptt int) (*Keyer, error) {
+	k := &Keyer{
+		generator: cwgenerator.New(opt),
+		opt:       opt,
+		port:      p,
+		key:       key,
+		ptt:       ptt,
+	}
+	// Start with the transmitter off
+	k.set(k.key, false)
+	if k.ptt != 0 {
+		k.set(k.ptt, false)
+	}
+	if k.err != nil {
+		_ = p.close()
+		return nil, k.err
+	}
+	keying := cwgenerator.Keying{
+		Key:  k.keyDit,
+		Stop: k.stop,
+	}
+	if k.ptt != 0 {
+		// Turn the transmitter on before the Morse and off once
+		// there has been none for the tail
+		keying.Start = k.pttOn
+		keying.Idle = k.pttOff
+		keying.Hold = opt.PTTTail
+	}
+	k.clock = cwgenerator.NewClock(k.generator, cwgenerator.DitDuration(opt.WPM), keying)
+	return k, nil
+}
+
+// set the line on or off, recording any error
+func (k *Keyer) set(line int, on bool) {
+	err := k.port.set(line, on)
+	if k.opt.Debug {
+		name := "key"
+		if line == k.ptt {
+			name = "ptt"
+		}
+		log.Printf("%s %v", name, on)
+	}
+	if err == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err == nil {
+		k.err = fmt.Errorf("failed to key serial port: %w", err)
+	}
+}
+
+// pttOn turns the PTT on and waits for the lead time
+func (k *Keyer) pttOn() {
+	k.set(k.ptt, true)
+	time.Sleep(k.opt.PTTLead)
+}
+
+// pttOff turns the PTT off
+func (k *Keyer) pttOff() {
+	k.set(k.ptt, false)
+}
+
+// keyDit sets the key line for the next dit
+func (k *Keyer) keyDit(n int, down bool) {
+	if down != k.keyed {
+		k.set(k.key, down)
+		k.keyed = down
+	}
+}
+
+// stop releases the key at the end of the Morse
+func (k *Keyer) stop(n int) {
+	k.keyDit(n, false)
+}
+
+// Rune adds r to the output
+func (k *Keyer) Rune(r rune) {
+	k.generator.Rune(r)
+	k.clock.Queue()
+}
+
+// String adds s to the output
+func (k *Keyer) String(s string) {
+	k.generator.String(s)
+	k.clock.Queue()
+}
+
+// Done returns a channel which is closed when all the Morse added so
+// far has been keyed.
+//
+// Adding more Morse makes a new channel so Done should be called
+// again afterwards.
+func (k *Keyer) Done() <-chan struct{} {
+	return k.clock.Done()
+}
+
+// Sync by waiting for all the Morse to be keyed
+func (k *Keyer) Sync() error {
+	<-k.Done()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+// Close the serial port after the Morse has been keyed and the PTT
+// released
+func (k *Keyer) Close() error {
+	k.clock.Close()
+	err := k.port.close()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return k.err
+	}
+	return err
+}
+
+// Check interfaces
+var (
+	_ cw.CW   = (*Keyer)(nil)
+	_ cw.Live = (*Keyer)(nil)
+)
@@ -0,0 +1,185 @@
+package cwserial
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// lineEvent is a change to a line of a fakePort
+type lineEvent struct {
+	line int
+	on   bool
+	at   time.Duration // since the fakePort was made
+}
+
+// fakePort records the changes to its lines
+type fakePort struct {
+	start  time.Time
+	mu     sync.Mutex
+	events []lineEvent
+	closed bool
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{start: time.Now()}
+}
+
+// set line on or off
+func (p *fakePort) set(line int, on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return fmt.Errorf("set %d %v after close", line, on)
+	}
+	p.events = append(p.events, lineEvent{line: line, on: on, at: time.Since(p.start)})
+	return nil
+}
+
+// close the port
+func (p *fakePort) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// sequence returns the events as a string, eg "key+ ptt-"
+func (p *fakePort) sequence(key, ptt int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []string
+	for _, e := range p.events {
+		name := "key"
+		if e.line == ptt {
+			name = "ptt"
+		}
+		state := "-"
+		if e.on {
+			state = "+"
+		}
+		out = append(out, name+state)
+	}
+	return strings.Join(out, " ")
+}
+
+// find returns the time of the nth event setting line to on
+func (p *fakePort) find(t *testing.T, line int, on bool, n int) time.Duration {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.events {
+		if e.line == line && e.on == on {
+			if n == 0 {
+				return e.at
+			}
+			n--
+		}
+	}
+	t.Fatalf("line %d not set to %v", line, on)
+	return 0
+}
+
+// near checks got is within tolerance of want
+func near(t *testing.T, what string, got, want time.Duration) {
+	t.Helper()
+	const tolerance = 15 * time.Millisecond
+	if got < want-tolerance || got > want+tolerance {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func testKeyer(t *testing.T) (*Keyer, *fakePort, int, int) {
+	opt := &cw.Options{
+		WPM:     60,
+		PTTLead: 50 * time.Millisecond,
+		PTTTail: 100 * time.Millisecond,
+	}
+	key, ptt := lines["dtr"], lines["rts"]
+	p := newFakePort()
+	k, err := newKeyer(opt, p, key, ptt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, p, key, ptt
+}
+
+func TestKeyer(t *testing.T) {
+	k, p, key, ptt := testKeyer(t)
+	dit := cwgenerator.DitDuration(k.opt.WPM)
+	select {
+	case <-k.Done():
+	default:
+		t.Error("Done not closed before anything was keyed")
+	}
+	start := time.Since(p.start)
+	k.String("A")
+	done := k.Done()
+	err := k.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	synced := time.Since(p.start)
+	select {
+	case <-done:
+	default:
+		t.Error("Done not closed after Sync")
+	}
+	err = k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := time.Since(p.start)
+
+	// Off to start with then the PTT around .-
+	want := "key- ptt- ptt+ key+ key- key+ key- ptt-"
+	if got := p.sequence(key, ptt); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	pttOn := p.find(t, ptt, true, 0)
+	near(t, "PTT on", pttOn, start)
+	keyOn := p.find(t, key, true, 0)
+	near(t, "lead", keyOn-pttOn, k.opt.PTTLead)
+	near(t, "dit", p.find(t, key, false, 1)-keyOn, dit)
+	near(t, "gap", p.find(t, key, true, 1)-p.find(t, key, false, 1), dit)
+	keyOff := p.find(t, key, false, 2)
+	near(t, "dah", keyOff-p.find(t, key, true, 1), 3*dit)
+	if synced < keyOff {
+		t.Errorf("Sync returned at %v before the key was released at %v", synced, keyOff)
+	}
+	pttOff := p.find(t, ptt, false, 1)
+	if pttOff-keyOff < k.opt.PTTTail {
+		t.Errorf("PTT released %v after the key, want at least %v", pttOff-keyOff, k.opt.PTTTail)
+	}
+	if closed < pttOff {
+		t.Errorf("Close returned at %v before the PTT was released at %v", closed, pttOff)
+	}
+	if !p.closed {
+		t.Error("port not closed")
+	}
+}
+
+func TestKeyerHoldsPTT(t *testing.T) {
+	k, p, key, ptt := testKeyer(t)
+	// More Morse within the tail keeps the PTT on
+	for i := 0; i < 2; i++ {
+		k.String("E")
+		err := k.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "key- ptt- ptt+ key+ key- key+ key- ptt-"
+	if got := p.sequence(key, ptt); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
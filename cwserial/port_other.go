@@ -0,0 +1,48 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package cwserial
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Serial port lines by name
+var lines = map[string]int{
+	"dtr": 1,
+	"rts": 2,
+}
+
+// port is a serial port
+type port struct {
+	*os.File
+}
+
+// openPort opens the serial port at path
+func openPort(path string) (*port, error) {
+	return nil, fmt.Errorf("serial ports aren't supported on %s", runtime.GOOS)
+}
+
+// checkLines returns an error if the port doesn't have DTR and RTS
+// lines
+func (p *port) checkLines() error {
+	return nil
+}
+
+// set line on or off
+func (p *port) set(line int, on bool) error {
+	return nil
+}
+
+// makeRaw sets the port to raw mode at 1200 baud with 8 data bits, no
+// parity and 2 stop bits, as used by the WinKeyer
+func (p *port) makeRaw() error {
+	return nil
+}
+
+// close the port
+func (p *port) close() error {
+	return p.Close()
+}
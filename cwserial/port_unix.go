@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package cwserial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Serial port lines by name
+var lines = map[string]int{
+	"dtr": unix.TIOCM_DTR,
+	"rts": unix.TIOCM_RTS,
+}
+
+// port is a serial port
+type port struct {
+	*os.File
+}
+
+// openPort opens the serial port at path
+func openPort(path string) (*port, error) {
+	// Don't wait for carrier detect or make this our controlling
+	// terminal. Non blocking so Close interrupts Read.
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port: %w", err)
+	}
+	return &port{File: f}, nil
+}
+
+// control calls fn with the file descriptor of the port
+func (p *port) control(fn func(fd int) error) error {
+	conn, err := p.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	err = conn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// checkLines returns an error if the port doesn't have DTR and RTS
+// lines
+func (p *port) checkLines() error {
+	err := p.control(func(fd int) error {
+		_, err := unix.IoctlGetInt(fd, unix.TIOCMGET)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%q doesn't have DTR and RTS lines: %w", p.Name(), err)
+	}
+	return nil
+}
+
+// set line on or off
+func (p *port) set(line int, on bool) error {
+	req := uint(unix.TIOCMBIC)
+	if on {
+		req = unix.TIOCMBIS
+	}
+	return p.control(func(fd int) error {
+		return unix.IoctlSetPointerInt(fd, req, line)
+	})
+}
+
+// makeRaw sets the port to raw mode at 1200 baud with 8 data bits, no
+// parity and 2 stop bits, as used by the WinKeyer
+func (p *port) makeRaw() error {
+	err := p.control(func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+		if err != nil {
+			return err
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CRTSCTS
+		t.Cflag |= unix.CS8 | unix.CSTOPB | unix.CLOCAL | unix.CREAD
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		setSpeed1200(t)
+		return unix.IoctlSetTermios(fd, ioctlWriteTermios, t)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up serial port %q: %w", p.Name(), err)
+	}
+	return nil
+}
+
+// close the port
+func (p *port) close() error {
+	return p.Close()
+}
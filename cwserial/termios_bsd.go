@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd || openbsd
+// +build darwin freebsd netbsd openbsd
+
+package cwserial
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlReadTermios  = unix.TIOCGETA
+	ioctlWriteTermios = unix.TIOCSETA
+)
+
+// setSpeed1200 sets the baud rate in t to 1200
+func setSpeed1200(t *unix.Termios) {
+	t.Ispeed = unix.B1200
+	t.Ospeed = unix.B1200
+}
@@ -0,0 +1,16 @@
+package cwserial
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlReadTermios  = unix.TCGETS
+	ioctlWriteTermios = unix.TCSETS
+)
+
+// setSpeed1200 sets the baud rate in t to 1200
+func setSpeed1200(t *unix.Termios) {
+	t.Cflag &^= unix.CBAUD
+	t.Cflag |= unix.B1200
+	t.Ispeed = unix.B1200
+	t.Ospeed = unix.B1200
+}
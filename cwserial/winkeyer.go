@@ -0,0 +1,431 @@
+package cwserial
+
+// WinKeyer output
+//
+// A K1EL WinKeyer (WK2 or WK3) sends Morse from text itself with its
+// own timing. The host sends it commands and text at 1200 baud and it
+// sends back status bytes whenever its state changes. These are used
+// for flow control, stopping sending while its buffer is nearly full,
+// and to find out when it has finished sending.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+)
+
+// WinKeyerScheme is the prefix of an output file naming the serial
+// port of a WinKeyer, eg "winkeyer:/dev/ttyUSB0"
+const WinKeyerScheme = "winkeyer:"
+
+// DefaultWeighting is the standard weighting of the elements
+const DefaultWeighting = 50
+
+// WinKeyer host protocol
+const (
+	wkAdmin      = 0x00 // admin command followed by one of wkAdmin*
+	wkAdminOpen  = 0x02 // start a host session - replies with the version
+	wkAdminClose = 0x03 // end the host session
+	wkSetWPM     = 0x02 // set the speed in WPM
+	wkWeighting  = 0x03 // set the weighting in percent
+	wkClear      = 0x0A // clear the buffer and stop sending
+	wkFarnsworth = 0x0D // set the character speed for Farnsworth
+	wkNull       = 0x13 // does nothing - used to synchronise
+	wkMerge      = 0x1B // send the next two characters as one
+
+	wkStatusMask = 0xE0 // status bytes are 110xxxxx
+	wkStatus     = 0xC0
+	wkXOFF       = 0x01 // status bit set when the buffer is 2/3 full
+	wkBusy       = 0x04 // status bit set while sending
+
+	wkMinWPM        = 5
+	wkMaxWPM        = 99
+	wkMinFarnsworth = 10
+	wkMinWeighting  = 10
+	wkMaxWeighting  = 90
+
+	wkChunk       = 8                      // most bytes to send between looking at the status
+	wkOpenTimeout = 2 * time.Second        // how long to wait for the WinKeyer to reply
+	wkBusyTimeout = 500 * time.Millisecond // how long to wait for the WinKeyer to start sending
+)
+
+// Characters the WinKeyer sends as themselves
+const wkRunes = `ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"'()+,-./:;=?@`
+
+// Characters the WinKeyer sends by Morse code, preferring letters
+var wkCodes = func() map[string]byte {
+	codes := map[string]byte{}
+	for _, r := range wkRunes {
+		code, _ := cwgenerator.Encode(&cw.Options{}, string(r))
+		if _, found := codes[code]; !found {
+			codes[code] = byte(r)
+		}
+	}
+	return codes
+}()
+
+// wkCode returns the bytes to send code, merging two characters if
+// there isn't a single one with that code, or nil if it can't be sent
+func wkCode(code string) []byte {
+	if c, found := wkCodes[code]; found {
+		return []byte{c}
+	}
+	for i := 1; i < len(code); i++ {
+		a, foundA := wkCodes[code[:i]]
+		b, foundB := wkCodes[code[i:]]
+		if foundA && foundB {
+			return []byte{wkMerge, a, b}
+		}
+	}
+	return nil
+}
+
+// WinKeyer sends the Morse with a K1EL WinKeyer
+type WinKeyer struct {
+	opt      *cw.Options
+	port     io.ReadWriteCloser
+	version  byte
+	finished chan struct{} // closed when the reader has finished
+
+	mu      sync.Mutex
+	cond    *sync.Cond    // signalled when any of the below change
+	pending []byte        // bytes waiting to be sent
+	status  byte          // last status from the WinKeyer
+	expect  bool          // set if text was sent while idle and the WinKeyer hasn't said it is busy yet
+	sentAt  time.Time     // when expect was last set
+	done    chan struct{} // closed when the WinKeyer has sent everything
+	closing bool          // set when the WinKeyer is being closed
+	err     error         // first error talking to the WinKeyer
+}
+
+// NewWinKeyer opens the WinKeyer on the serial port named by
+// opt.OutputFile which should start with WinKeyerScheme, and sets its
+// speed and weighting from opt
+func NewWinKeyer(opt *cw.Options) (*WinKeyer, error) {
+	path := strings.TrimPrefix(opt.OutputFile, WinKeyerScheme)
+	if path == "" {
+		return nil, fmt.Errorf("need a serial port after %q", WinKeyerScheme)
+	}
+	settings, err := wkSettings(opt)
+	if err != nil {
+		return nil, err
+	}
+	p, err := openPort(path)
+	if err != nil {
+		return nil, err
+	}
+	err = p.makeRaw()
+	if err != nil {
+		_ = p.close()
+		return nil, err
+	}
+	k, err := newWinKeyer(opt, p, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WinKeyer on %q: %w", path, err)
+	}
+	if opt.Debug {
+		log.Printf("WinKeyer version %d on %q", k.version, path)
+	}
+	return k, nil
+}
+
+// newWinKeyer opens the WinKeyer connected to port, which should
+// already be set up to talk to it, and sends it the settings.
+//
+// port is closed if this fails.
+func newWinKeyer(opt *cw.Options, port io.ReadWriteCloser, settings []byte) (*WinKeyer, error) {
+	k := &WinKeyer{
+		opt:      opt,
+		port:     port,
+		finished: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	k.cond = sync.NewCond(&k.mu)
+	close(k.done) // nothing to send yet
+	version := make(chan byte, 1)
+	go k.read(version)
+	err := k.open(version, settings)
+	if err != nil {
+		k.mu.Lock()
+		k.closing = true
+		k.mu.Unlock()
+		_ = port.Close()
+		<-k.finished
+		return nil, err
+	}
+	go k.write()
+	return k, nil
+}
+
+// wkSettings returns the commands to set the WinKeyer up from opt
+func wkSettings(opt *cw.Options) ([]byte, error) {
+	wpm := int(math.Round(opt.WPM))
+	if wpm < wkMinWPM || wpm > wkMaxWPM {
+		return nil, fmt.Errorf("WinKeyer speed must be between %d and %d WPM", wkMinWPM, wkMaxWPM)
+	}
+	weighting := opt.Weighting
+	if weighting == 0 {
+		weighting = DefaultWeighting
+	}
+	if weighting < wkMinWeighting || weighting > wkMaxWeighting {
+		return nil, fmt.Errorf("WinKeyer weighting must be between %d and %d", wkMinWeighting, wkMaxWeighting)
+	}
+	settings := []byte{wkClear, wkWeighting, byte(weighting)}
+	if opt.Farnsworth > 0 && opt.Farnsworth < opt.WPM {
+		// The WinKeyer sends the characters at the Farnsworth speed
+		// and spaces them out to the WPM
+		farnsworth := int(math.Round(opt.Farnsworth))
+		if farnsworth < wkMinWPM || wpm < wkMinFarnsworth {
+			return nil, fmt.Errorf("WinKeyer Farnsworth needs --farnsworth at least %d and --wpm at least %d", wkMinWPM, wkMinFarnsworth)
+		}
+		settings = append(settings, wkFarnsworth, byte(wpm), wkSetWPM, byte(farnsworth))
+	} else {
+		settings = append(settings, wkSetWPM, byte(wpm))
+	}
+	return settings, nil
+}
+
+// open the host session, waiting for the reader to send the version,
+// then send the settings
+func (k *WinKeyer) open(version <-chan byte, settings []byte) error {
+	_, err := k.port.Write([]byte{wkNull, wkNull, wkNull, wkAdmin, wkAdminOpen})
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(wkOpenTimeout)
+	defer timer.Stop()
+	select {
+	case k.version = <-version:
+	case <-k.finished:
+		// The reader has failed
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return fmt.Errorf("no reply: %w", k.err)
+	case <-timer.C:
+		return fmt.Errorf("no reply after %v", wkOpenTimeout)
+	}
+	_, err = k.port.Write(settings)
+	if err != nil {
+		return fmt.Errorf("failed to set up WinKeyer: %w", err)
+	}
+	return nil
+}
+
+// fail records err and abandons anything waiting to be sent, call
+// with the lock held
+func (k *WinKeyer) _fail(err error) {
+	if k.err == nil {
+		k.err = fmt.Errorf("WinKeyer failed: %w", err)
+	}
+	k.pending = nil
+	k.expect = false
+	k.status = 0
+	k._checkIdle()
+}
+
+// close done if the WinKeyer has sent everything, call with the lock
+// held
+func (k *WinKeyer) _checkIdle() {
+	k.cond.Broadcast()
+	if len(k.pending) > 0 || k.expect || k.status&wkBusy != 0 {
+		return
+	}
+	select {
+	case <-k.done:
+	default:
+		close(k.done)
+	}
+}
+
+// read the status bytes from the WinKeyer until the port is closed.
+//
+// The first byte which isn't a status or speed pot byte is the reply
+// to opening the host session which is sent to version.
+func (k *WinKeyer) read(version chan<- byte) {
+	defer close(k.finished)
+	var buf [64]byte
+	for {
+		n, err := k.port.Read(buf[:])
+		k.mu.Lock()
+		for _, b := range buf[:n] {
+			if version != nil && b&0x80 == 0 {
+				version <- b
+				version = nil
+				continue
+			}
+			// Ignore speed pot and echo bytes
+			if b&wkStatusMask != wkStatus {
+				continue
+			}
+			k.status = b
+			if b&wkBusy != 0 {
+				k.expect = false
+			}
+		}
+		if err != nil {
+			if !k.closing {
+				k._fail(err)
+			}
+			k.mu.Unlock()
+			return
+		}
+		k._checkIdle()
+		k.mu.Unlock()
+	}
+}
+
+// write the pending bytes to the WinKeyer while it has room for them
+func (k *WinKeyer) write() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for {
+		for !k.closing && (len(k.pending) == 0 || k.status&wkXOFF != 0) {
+			k.cond.Wait()
+		}
+		if k.closing {
+			return
+		}
+		n := len(k.pending)
+		if n > wkChunk {
+			n = wkChunk
+		}
+		chunk := append([]byte(nil), k.pending[:n]...)
+		k.pending = k.pending[n:]
+		if k.status&wkBusy == 0 && !k.expect {
+			// Wait for the WinKeyer to say it is busy, but not
+			// forever in case what was sent takes no time
+			k.expect = true
+			k.sentAt = time.Now()
+			time.AfterFunc(wkBusyTimeout, func() {
+				k.mu.Lock()
+				defer k.mu.Unlock()
+				if k.expect && time.Since(k.sentAt) >= wkBusyTimeout {
+					k.expect = false
+					k._checkIdle()
+				}
+			})
+		}
+		k.mu.Unlock()
+		_, err := k.port.Write(chunk)
+		k.mu.Lock()
+		if err != nil {
+			k._fail(err)
+		}
+	}
+}
+
+// add b to the bytes to send
+func (k *WinKeyer) add(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return
+	}
+	k.pending = append(k.pending, b...)
+	select {
+	case <-k.done:
+		k.done = make(chan struct{})
+	default:
+	}
+	k.cond.Broadcast()
+}
+
+// encode r into the bytes to send to the WinKeyer
+//
+// Runes with no Morse code are dealt with according to the Unknown
+// policy in the options. With cw.UnknownError nothing more is sent and
+// the error is returned by Sync and Close.
+func (k *WinKeyer) encode(r rune) (out []byte) {
+	if unicode.IsSpace(r) {
+		return []byte{' '}
+	}
+	morse, err := cwgenerator.Encode(k.opt, string(r))
+	if err != nil {
+		k.mu.Lock()
+		k._fail(err)
+		k.mu.Unlock()
+		return nil
+	}
+	for _, code := range strings.Fields(morse) {
+		if code == "/" {
+			out = append(out, ' ')
+			continue
+		}
+		b := wkCode(code)
+		if b == nil && k.opt.Debug {
+			log.Printf("Can't send %q on the WinKeyer", code)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
+
+// Version returns the firmware version of the WinKeyer, eg 23 for
+// version 2.3
+func (k *WinKeyer) Version() int {
+	return int(k.version)
+}
+
+// Rune adds r to the output
+func (k *WinKeyer) Rune(r rune) {
+	k.add(k.encode(r))
+}
+
+// String adds s to the output
+func (k *WinKeyer) String(s string) {
+	var out []byte
+	for _, r := range s {
+		out = append(out, k.encode(r)...)
+	}
+	k.add(out)
+}
+
+// Done returns a channel which is closed when the WinKeyer has sent
+// all the Morse added so far.
+//
+// Adding more Morse makes a new channel so Done should be called
+// again afterwards.
+func (k *WinKeyer) Done() <-chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.done
+}
+
+// Sync by waiting for the WinKeyer to send all the Morse
+func (k *WinKeyer) Sync() error {
+	<-k.Done()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+// Close the WinKeyer after it has sent all the Morse
+func (k *WinKeyer) Close() error {
+	err := k.Sync()
+	k.mu.Lock()
+	k.closing = true
+	k.cond.Broadcast()
+	k.mu.Unlock()
+	_, closeErr := k.port.Write([]byte{wkAdmin, wkAdminClose})
+	err = errors.Join(err, closeErr, k.port.Close())
+	<-k.finished
+	return err
+}
+
+// Check interfaces
+var (
+	_ cw.CW   = (*WinKeyer)(nil)
+	_ cw.Live = (*WinKeyer)(nil)
+)
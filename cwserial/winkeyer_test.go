@@ -0,0 +1,339 @@
+package cwserial
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncw/cwtool/cw"
+)
+
+const (
+	emuVersion  = 31                   // version the emulator replies with
+	emuXOFF     = 6                    // characters buffered before XOFF
+	emuCharTime = 3 * time.Millisecond // how long each character takes to send
+	emuByteTime = time.Millisecond     // how long each byte takes to arrive, like a serial line
+)
+
+// emulator pretends to be a WinKeyer on the other end of a pipe
+type emulator struct {
+	t        *testing.T
+	conn     net.Conn
+	wake     chan struct{} // sent to when text is buffered
+	statuses chan byte     // status bytes to send in order
+	finished chan struct{} // closed when the host has closed the pipe
+	wmu      sync.Mutex    // held while writing to conn
+
+	mu         sync.Mutex
+	received   []byte // everything the host sent
+	settings   []byte // commands before the first text
+	text       []byte // text bytes, with merges as wkMerge a b
+	wpm        byte
+	weighting  byte
+	farnsworth byte
+	buffered   int       // characters waiting to be sent
+	maxBuffer  int       // most characters buffered
+	xoffSeen   bool      // set when the host has read the current XOFF
+	afterXOFF  int       // characters received since the host read XOFF
+	maxXOFF    int       // most characters received after the host read XOFF
+	status     byte      // last status sent
+	idleAt     time.Time // when the buffer last emptied
+	closedAt   time.Time // when the host session was closed
+	closedLen  int       // length of text when it was closed
+}
+
+// newEmulator starts an emulator returning the host end of its pipe
+func newEmulator(t *testing.T) (*emulator, net.Conn) {
+	host, conn := net.Pipe()
+	e := &emulator{
+		t:        t,
+		conn:     conn,
+		wake:     make(chan struct{}, 1),
+		statuses: make(chan byte, 1024),
+		finished: make(chan struct{}),
+		status:   wkStatus,
+	}
+	go e.read()
+	go e.send()
+	go e.sendStatus()
+	return e, host
+}
+
+// wait for the host to close the pipe
+func (e *emulator) wait() {
+	<-e.finished
+}
+
+// sendStatus sends the status bytes to the host in order
+func (e *emulator) sendStatus() {
+	for status := range e.statuses {
+		e.write(status)
+		e.mu.Lock()
+		if status == e.status && status&wkXOFF != 0 && !e.xoffSeen {
+			e.xoffSeen = true
+			e.afterXOFF = 0
+		}
+		e.mu.Unlock()
+	}
+}
+
+// write b to the host
+func (e *emulator) write(b ...byte) {
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+	_, _ = e.conn.Write(b)
+}
+
+// _setStatus works out the status sending it if it changed, call with
+// the lock held
+func (e *emulator) _setStatus() {
+	status := byte(wkStatus)
+	if e.buffered > 0 {
+		status |= wkBusy
+	}
+	if e.buffered >= emuXOFF {
+		status |= wkXOFF
+	}
+	if status == e.status {
+		return
+	}
+	e.status = status
+	e.xoffSeen = false
+	e.statuses <- status
+}
+
+// read and act on the commands from the host until it closes the pipe
+func (e *emulator) read() {
+	defer close(e.finished)
+	defer func() {
+		_ = e.conn.Close()
+	}()
+	var buf [1]byte
+	next := func() byte {
+		time.Sleep(emuByteTime)
+		_, err := io.ReadFull(e.conn, buf[:])
+		if err != nil {
+			panic(err)
+		}
+		e.mu.Lock()
+		e.received = append(e.received, buf[0])
+		e.mu.Unlock()
+		return buf[0]
+	}
+	defer func() {
+		if r := recover(); r != nil && r != io.EOF {
+			e.t.Errorf("emulator read failed: %v", r)
+		}
+	}()
+	for {
+		c := next()
+		var arg byte
+		switch c {
+		case wkAdmin:
+			switch next() {
+			case wkAdminOpen:
+				// Speed pot byte then the version
+				e.write(0x80, emuVersion)
+			case wkAdminClose:
+				e.mu.Lock()
+				e.closedAt = time.Now()
+				e.closedLen = len(e.text)
+				e.mu.Unlock()
+			}
+			continue
+		case wkNull:
+			continue
+		case wkSetWPM, wkWeighting, wkFarnsworth:
+			arg = next()
+		case wkClear:
+		case wkMerge:
+			a, b := next(), next()
+			e.buffer(wkMerge, a, b)
+			continue
+		default:
+			e.buffer(c)
+			continue
+		}
+		e.mu.Lock()
+		if len(e.text) == 0 {
+			e.settings = append(e.settings, c)
+			if c != wkClear {
+				e.settings = append(e.settings, arg)
+			}
+		}
+		switch c {
+		case wkSetWPM:
+			e.wpm = arg
+		case wkWeighting:
+			e.weighting = arg
+		case wkFarnsworth:
+			e.farnsworth = arg
+		}
+		e.mu.Unlock()
+	}
+}
+
+// buffer a character to send
+func (e *emulator) buffer(b ...byte) {
+	e.mu.Lock()
+	e.text = append(e.text, b...)
+	e.buffered++
+	if e.buffered > e.maxBuffer {
+		e.maxBuffer = e.buffered
+	}
+	if e.xoffSeen {
+		e.afterXOFF++
+		if e.afterXOFF > e.maxXOFF {
+			e.maxXOFF = e.afterXOFF
+		}
+	}
+	e._setStatus()
+	e.mu.Unlock()
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send the buffered characters
+func (e *emulator) send() {
+	for range e.wake {
+		for {
+			e.mu.Lock()
+			if e.buffered == 0 {
+				e.mu.Unlock()
+				break
+			}
+			e.mu.Unlock()
+			time.Sleep(emuCharTime)
+			e.mu.Lock()
+			e.buffered--
+			if e.buffered == 0 {
+				e.idleAt = time.Now()
+			}
+			e._setStatus()
+			e.mu.Unlock()
+		}
+	}
+}
+
+// testWinKeyer opens a WinKeyer on an emulator
+func testWinKeyer(t *testing.T, opt *cw.Options) (*WinKeyer, *emulator) {
+	e, host := newEmulator(t)
+	settings, err := wkSettings(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := newWinKeyer(opt, host, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, e
+}
+
+func TestWinKeyerOpen(t *testing.T) {
+	k, e := testWinKeyer(t, &cw.Options{WPM: 20, Farnsworth: 12, Weighting: 60})
+	err := k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.wait()
+	if k.Version() != emuVersion {
+		t.Errorf("version %d, want %d", k.Version(), emuVersion)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	open := []byte{wkNull, wkNull, wkNull, wkAdmin, wkAdminOpen}
+	if !bytes.HasPrefix(e.received, open) {
+		t.Errorf("got % x, want to start with % x", e.received, open)
+	}
+	if e.weighting != 60 || e.farnsworth != 20 || e.wpm != 12 {
+		t.Errorf("got weighting %d, Farnsworth %d, WPM %d, want 60, 20, 12", e.weighting, e.farnsworth, e.wpm)
+	}
+	closing := []byte{wkAdmin, wkAdminClose}
+	if !bytes.HasSuffix(e.received, closing) {
+		t.Errorf("got % x, want to end with % x", e.received, closing)
+	}
+}
+
+func TestWinKeyerNoReply(t *testing.T) {
+	host, conn := net.Pipe()
+	go func() {
+		// Read the handshake then hang up
+		var buf [5]byte
+		_, _ = io.ReadFull(conn, buf[:])
+		_ = conn.Close()
+	}()
+	_, err := newWinKeyer(&cw.Options{WPM: 20}, host, nil)
+	if err == nil {
+		t.Fatal("expected an error with no reply")
+	}
+}
+
+func TestWinKeyerMerge(t *testing.T) {
+	k, e := testWinKeyer(t, &cw.Options{WPM: 20})
+	// _ has no character of its own so is sent as I and Q merged
+	k.String("A_")
+	err := k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.wait()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	want := []byte{'A', wkMerge, 'I', 'Q'}
+	if !bytes.Equal(e.text, want) {
+		t.Errorf("got % x, want % x", e.text, want)
+	}
+}
+
+func TestWinKeyerFlowControl(t *testing.T) {
+	k, e := testWinKeyer(t, &cw.Options{WPM: 20})
+	text := "THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG 0123456789"
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		k.String(text)
+		done := k.Done()
+		err := k.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		synced := time.Now()
+		select {
+		case <-done:
+		default:
+			t.Error("Done not closed after Sync")
+		}
+		e.mu.Lock()
+		idleAt := e.idleAt
+		e.mu.Unlock()
+		if idleAt.Before(start) || synced.Before(idleAt) {
+			t.Errorf("Sync returned at %v, the WinKeyer was idle at %v", synced.Sub(start), idleAt.Sub(start))
+		}
+	}
+	// Close waits for the WinKeyer to finish too
+	k.String(text)
+	err := k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.wait()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if want := bytes.Repeat([]byte(text), 3); !bytes.Equal(e.text, want) {
+		t.Errorf("got %q, want %q", e.text, want)
+	}
+	if e.maxBuffer < emuXOFF {
+		t.Errorf("only %d characters buffered so XOFF wasn't tested", e.maxBuffer)
+	}
+	// Only the chunk being written when XOFF arrives may follow it
+	if e.maxXOFF > wkChunk {
+		t.Errorf("sent %d characters after XOFF, want at most %d", e.maxXOFF, wkChunk)
+	}
+	if e.closedAt.Before(e.idleAt) || e.closedLen != len(e.text) {
+		t.Errorf("host session closed before the WinKeyer was idle")
+	}
+}
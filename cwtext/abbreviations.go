@@ -0,0 +1,181 @@
+package cwtext
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Abbreviations maps plain English words and phrases onto the
+// abbreviations and Q codes used on air
+var Abbreviations = map[string]string{
+	"about":            "ABT",
+	"again":            "AGN",
+	"and":              "ES",
+	"antenna":          "ANT",
+	"are":              "R",
+	"before":           "BFR",
+	"best regards":     "73",
+	"change frequency": "QSY",
+	"conditions":       "CONDX",
+	"contact":          "QSO",
+	"copy":             "CPY",
+	"fading":           "QSB",
+	"fine business":    "FB",
+	"for":              "FER", // more usual on air than FR, eg TNX FER CALL
+	"frequency":        "FREQ",
+	"good":             "GUD",
+	"good afternoon":   "GA",
+	"good evening":     "GE",
+	"good luck":        "GL",
+	"good morning":     "GM",
+	"good night":       "GN",
+	"here":             "HR",
+	"how":              "HW",
+	"interference":     "QRM",
+	"location":         "QTH",
+	"many":             "MNI",
+	"message":          "MSG",
+	"noise":            "QRN",
+	"nothing":          "NIL",
+	"now":              "NW",
+	"number":           "NR",
+	"old man":          "OM",
+	"operator":         "OP",
+	"please":           "PSE",
+	"power":            "PWR",
+	"really":           "RLY",
+	"received":         "RCVD",
+	"receiver":         "RX",
+	"report":           "RPT",
+	"see you":          "CU",
+	"see you later":    "CUL",
+	"send faster":      "QRQ",
+	"send slower":      "QRS",
+	"signal":           "SIG",
+	"sorry":            "SRI",
+	"station":          "STN",
+	"temperature":      "TEMP",
+	"thank you":        "TU",
+	"thanks":           "TNX",
+	"transmitter":      "TX",
+	"very":             "VY",
+	"weather":          "WX",
+	"will":             "WL",
+	"with":             "W",
+	"word":             "WD",
+	"words":            "WDS",
+	"worked":           "WKD",
+	"would":            "WUD",
+	"year":             "YR",
+	"years":            "YRS",
+	"you":              "U",
+	"you are":          "UR",
+	"you're":           "UR",
+	"your":             "UR",
+	"young lady":       "YL",
+}
+
+// Abbreviator replaces words and phrases with their abbreviations
+type Abbreviator struct {
+	mu   sync.Mutex
+	dict map[string]string // lower case phrase with single spaces to abbreviation
+	re   *regexp.Regexp    // matches any phrase in dict, nil if needs rebuilding
+}
+
+// NewAbbreviator makes an Abbreviator from the dictionary passed in
+// which maps phrases onto their abbreviations
+func NewAbbreviator(dict map[string]string) *Abbreviator {
+	a := &Abbreviator{
+		dict: make(map[string]string, len(dict)),
+	}
+	for phrase, abbreviation := range dict {
+		a.Add(phrase, abbreviation)
+	}
+	return a
+}
+
+// Tidy a phrase into the form used as a key in dict
+func key(phrase string) string {
+	return strings.ToLower(Whitespace(phrase))
+}
+
+// Add phrase to the dictionary, replacing any existing abbreviation
+func (a *Abbreviator) Add(phrase, abbreviation string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dict[key(phrase)] = abbreviation
+	a.re = nil
+}
+
+// Load extra abbreviations from file.
+//
+// Each line of the file should contain a phrase and its abbreviation
+// separated by =, eg "good day = GD". Blank lines and lines starting
+// with # are ignored.
+func (a *Abbreviator) Load(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open abbreviations: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	scanner := bufio.NewScanner(in)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		phrase, abbreviation, found := strings.Cut(line, "=")
+		phrase, abbreviation = strings.TrimSpace(phrase), strings.TrimSpace(abbreviation)
+		if !found || phrase == "" {
+			return fmt.Errorf("%s:%d: expecting phrase = abbreviation but got %q", file, lineNumber, line)
+		}
+		a.Add(phrase, abbreviation)
+	}
+	return scanner.Err()
+}
+
+// Make the regexp which matches any phrase, call with the lock held
+func (a *Abbreviator) _regexp() *regexp.Regexp {
+	if a.re != nil {
+		return a.re
+	}
+	phrases := make([]string, 0, len(a.dict))
+	for phrase := range a.dict {
+		phrases = append(phrases, phrase)
+	}
+	// Longest first so phrases match in preference to their words
+	sort.Slice(phrases, func(i, j int) bool {
+		if len(phrases[i]) != len(phrases[j]) {
+			return len(phrases[i]) > len(phrases[j])
+		}
+		return phrases[i] < phrases[j]
+	})
+	for i, phrase := range phrases {
+		phrases[i] = strings.ReplaceAll(regexp.QuoteMeta(phrase), " ", `\s+`)
+	}
+	a.re = regexp.MustCompile(`(?i)\b(?:` + strings.Join(phrases, "|") + `)\b`)
+	return a.re
+}
+
+// Abbreviate replaces all the words and phrases in s which have
+// abbreviations.
+//
+// The matching ignores case and the replacements aren't looked at
+// again, so the result of one abbreviation is never abbreviated.
+func (a *Abbreviator) Abbreviate(s string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.dict) == 0 {
+		return s
+	}
+	return a._regexp().ReplaceAllStringFunc(s, func(phrase string) string {
+		return a.dict[key(phrase)]
+	})
+}
@@ -0,0 +1,109 @@
+package cwtext
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAbbreviate(t *testing.T) {
+	a := NewAbbreviator(Abbreviations)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"thank you for the report", "TU FER the RPT"},
+		{"Best Regards", "73"},
+		{"BEST  regards", "73"},
+		{"best\tregards", "73"},
+		{"see you later", "CUL"},
+		{"see you soon", "CU soon"},
+		{"you're good", "UR GUD"},
+		{"andrew forgot", "andrew forgot"},
+		{"sand for", "sand FER"},
+		{"you,are", "U,R"},
+		{"good morning, old man.", "GM, OM."},
+	} {
+		got := a.Abbreviate(test.in)
+		if got != test.want {
+			t.Errorf("Abbreviate(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestAbbreviateOnce(t *testing.T) {
+	a := NewAbbreviator(map[string]string{
+		"one": "two",
+		"two": "three",
+	})
+	got := a.Abbreviate("one two")
+	want := "two three"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAbbreviateEmpty(t *testing.T) {
+	a := NewAbbreviator(nil)
+	got := a.Abbreviate("thank you")
+	if got != "thank you" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	a := NewAbbreviator(Abbreviations)
+	err := a.Load(write("good.txt", `# My abbreviations
+
+good day = GD
+  Thank   You = TKS
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"good day", "GD"},
+		{"Good Day", "GD"},
+		{"thank you", "TKS"}, // replaces the built in one
+		{"good evening", "GE"},
+	} {
+		got := a.Abbreviate(test.in)
+		if got != test.want {
+			t.Errorf("Abbreviate(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		contents string
+		wantErr  string
+	}{
+		{"noequals.txt", "good day GD\n", "noequals.txt:1: expecting phrase = abbreviation"},
+		{"nophrase.txt", "# comment\n = GD\n", "nophrase.txt:2: expecting phrase = abbreviation"},
+	} {
+		err := a.Load(write(test.name, test.contents))
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+
+	err = a.Load(filepath.Join(dir, "missing.txt"))
+	if err == nil || !strings.Contains(err.Error(), "failed to open abbreviations") {
+		t.Errorf("missing file: got error %v", err)
+	}
+}
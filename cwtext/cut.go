@@ -0,0 +1,60 @@
+package cwtext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cutNumbers maps digits onto the letters sent for them as cut
+// numbers, as used in contests, eg 5NN for 599
+var cutNumbers = map[rune]rune{
+	'0': 'T',
+	'1': 'A',
+	'2': 'U',
+	'3': 'V',
+	'5': 'E',
+	'7': 'B',
+	'8': 'D',
+	'9': 'N',
+}
+
+// CutDigits are all the digits which have cut numbers
+const CutDigits = "01235789"
+
+// ParseCutDigits parses s which should be a list of digits with cut
+// numbers, or "all" for all of them.
+//
+// It returns the digits to cut.
+func ParseCutDigits(s string) (string, error) {
+	if strings.EqualFold(s, "all") {
+		return CutDigits, nil
+	}
+	for _, r := range s {
+		if _, found := cutNumbers[r]; !found {
+			return "", fmt.Errorf("no cut number for %q: use some of %q or all", r, CutDigits)
+		}
+	}
+	return s, nil
+}
+
+// CutRune returns the cut number for r if r is one of digits,
+// otherwise it returns r
+func CutRune(r rune, digits string) rune {
+	if !strings.ContainsRune(digits, r) {
+		return r
+	}
+	if cut, found := cutNumbers[r]; found {
+		return cut
+	}
+	return r
+}
+
+// Cut returns a Step which sends digits as cut numbers, eg with
+// digits "09" 1009 is sent as 1TTN
+func Cut(digits string) Step {
+	return func(s string) string {
+		return strings.Map(func(r rune) rune {
+			return CutRune(r, digits)
+		}, s)
+	}
+}
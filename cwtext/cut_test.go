@@ -0,0 +1,81 @@
+package cwtext
+
+import "testing"
+
+func TestParseCutDigits(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"09", "09", false},
+		{"all", CutDigits, false},
+		{"ALL", CutDigits, false},
+		{"", "", false},
+		{"4", "", true},
+		{"0x", "", true},
+	} {
+		got, err := ParseCutDigits(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseCutDigits(%q) error = %v, want error %v", test.in, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("ParseCutDigits(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCutRune(t *testing.T) {
+	for _, test := range []struct {
+		r      rune
+		digits string
+		want   rune
+	}{
+		{'0', "09", 'T'},
+		{'9', "09", 'N'},
+		{'1', "09", '1'},
+		{'1', CutDigits, 'A'},
+		{'2', CutDigits, 'U'},
+		{'3', CutDigits, 'V'},
+		{'5', CutDigits, 'E'},
+		{'7', CutDigits, 'B'},
+		{'8', CutDigits, 'D'},
+		{'4', "4", '4'}, // no cut number
+		{'A', CutDigits, 'A'},
+	} {
+		got := CutRune(test.r, test.digits)
+		if got != test.want {
+			t.Errorf("CutRune(%q, %q) = %q, want %q", test.r, test.digits, got, test.want)
+		}
+	}
+}
+
+func TestCut(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		digits string
+		want   string
+	}{
+		{"599", "9", "5NN"},
+		{"599", CutDigits, "ENN"},
+		{"1009", "09", "1TTN"},
+		{"RST 599 NR 14", "9", "RST 5NN NR 14"},
+		{"no digits", CutDigits, "no digits"},
+		{"", CutDigits, ""},
+	} {
+		got := Cut(test.digits)(test.in)
+		if got != test.want {
+			t.Errorf("Cut(%q)(%q) = %q, want %q", test.digits, test.in, got, test.want)
+		}
+	}
+}
+
+// Abbreviations are applied after cutting so their digits are kept
+func TestCutThenAbbreviate(t *testing.T) {
+	n := New(Cut(CutDigits), NewAbbreviator(Abbreviations).Abbreviate)
+	got := n.Normalise("599 best regards")
+	want := "ENN 73"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
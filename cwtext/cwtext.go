@@ -0,0 +1,207 @@
+// Package cwtext normalises text so it is suitable for sending as Morse code
+package cwtext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Step is a single normalisation step which transforms s
+type Step func(s string) string
+
+// Normaliser applies a series of Steps to text
+type Normaliser struct {
+	steps []Step
+}
+
+// New makes a Normaliser which applies steps in order
+func New(steps ...Step) *Normaliser {
+	return &Normaliser{
+		steps: steps,
+	}
+}
+
+// Add steps to the end of the Normaliser
+func (n *Normaliser) Add(steps ...Step) {
+	n.steps = append(n.steps, steps...)
+}
+
+// Normalise s by applying all the steps in order
+func (n *Normaliser) Normalise(s string) string {
+	for _, step := range n.steps {
+		s = step(s)
+	}
+	return s
+}
+
+// StepInfo describes a named Step
+type StepInfo struct {
+	Name string // name used to select the step
+	Help string // short description of what it does
+	Step Step
+}
+
+// Steps is the list of named steps in the order they should be applied
+var Steps = []StepInfo{
+	{Name: "quotes", Help: "fold smart quotes, dashes and ellipses into plain ASCII", Step: Quotes},
+	{Name: "urls", Help: "replace URLs with their host name and tidy email addresses", Step: URLs},
+	{Name: "sentences", Help: "separate sentences with BT", Step: Sentences},
+	{Name: "punctuation", Help: "map punctuation onto characters with Morse code", Step: Punctuation},
+	{Name: "whitespace", Help: "collapse runs of whitespace into a single space", Step: Whitespace},
+}
+
+// DefaultSteps are the names of the steps used if none are configured
+var DefaultSteps = []string{"quotes", "urls", "punctuation", "whitespace"}
+
+// StepNames returns the names of all the steps
+func StepNames() (names []string) {
+	for _, info := range Steps {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+// Find the named step
+func Find(name string) (Step, error) {
+	for _, info := range Steps {
+		if strings.EqualFold(name, info.Name) {
+			return info.Step, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown normalisation step %q: must be one of %s", name, strings.Join(StepNames(), ", "))
+}
+
+// Parse makes a Normaliser from the named steps.
+//
+// The steps are applied in the order they are given. The name "none"
+// may be used on its own to disable normalisation.
+func Parse(names []string) (*Normaliser, error) {
+	n := New()
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.EqualFold(name, "none") {
+			continue
+		}
+		step, err := Find(name)
+		if err != nil {
+			return nil, err
+		}
+		n.Add(step)
+	}
+	return n, nil
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Whitespace collapses runs of whitespace into a single space and
+// removes it from the start and end
+func Whitespace(s string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(s, " "))
+}
+
+var quotes = strings.NewReplacer(
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "«", `"`, "»", `"`,
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "‹", "'", "›", "'", "`", "'", "´", "'",
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "―", "-", "−", "-",
+	"…", "...",
+)
+
+// Quotes folds smart quotes, dashes and ellipses into their plain
+// ASCII equivalents
+func Quotes(s string) string {
+	return quotes.Replace(s)
+}
+
+var (
+	urlRe   = regexp.MustCompile(`(?i)\b(?:https?|ftp)://(?:www\.)?([^/\s:?#]+)[^\s]*`)
+	emailRe = regexp.MustCompile(`(?i)\b(?:mailto:)?([a-z0-9._%+-]+)@([a-z0-9.-]+\.[a-z]+)\b`)
+)
+
+// URLs replaces URLs with their host name (without any leading
+// www.) and removes mailto: from email addresses
+func URLs(s string) string {
+	s = urlRe.ReplaceAllString(s, "$1")
+	s = emailRe.ReplaceAllString(s, "$1@$2")
+	return s
+}
+
+var sentenceRe = regexp.MustCompile(`([.!?])\s+(\p{Lu})`)
+
+// Words which end in a "." without ending the sentence
+var titles = map[string]bool{
+	"MR":   true,
+	"MRS":  true,
+	"MS":   true,
+	"DR":   true,
+	"PROF": true,
+	"REV":  true,
+	"ST":   true,
+	"MT":   true,
+	"SR":   true,
+	"JR":   true,
+	"VS":   true,
+	"GEN":  true,
+	"COL":  true,
+	"CAPT": true,
+	"SGT":  true,
+	"LT":   true,
+}
+
+// Sentences separates sentences with a BT (sent as =).
+//
+// A sentence ends with a ".", "!" or "?" followed by whitespace and a
+// capital letter. A "." after a title like "Mr" or an initial doesn't
+// end one.
+func Sentences(s string) string {
+	var (
+		out  strings.Builder
+		last = 0
+	)
+	for _, m := range sentenceRe.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[2], m[3]
+		if s[start] == '.' {
+			word := s[:start]
+			if i := strings.LastIndexFunc(word, unicode.IsSpace); i >= 0 {
+				word = word[i+1:]
+			}
+			word = strings.ToUpper(word)
+			if titles[word] || utf8.RuneCountInString(word) == 1 {
+				continue
+			}
+			end = start
+		}
+		out.WriteString(s[last:end])
+		out.WriteString(" = ")
+		// Carry on from the capital letter
+		last = m[4]
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
+
+var punctuation = strings.NewReplacer(
+	":", " =",
+	"!", ".",
+	";", ",",
+	"&", " AND ",
+	"%", " PERCENT ",
+	"#", " NR ",
+	"|", "/",
+	"[", "(",
+	"]", ")",
+	"{", "(",
+	"}", ")",
+	"<", "(",
+	">", ")",
+)
+
+// Punctuation maps punctuation with no Morse code, or which isn't
+// commonly sent, onto nearby characters which can be sent.
+//
+// A : is sent as a BT for example.
+func Punctuation(s string) string {
+	return punctuation.Replace(s)
+}
@@ -0,0 +1,142 @@
+package cwtext
+
+import "testing"
+
+func TestSentences(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"Hello there", "Hello there"},
+		{"One. Two", "One = Two"},
+		{"One.  Two. Three.", "One = Two = Three."},
+		{"Really? Yes! Good", "Really? = Yes! = Good"},
+		{"Mr. Smith went home. He slept", "Mr. Smith went home = He slept"},
+		{"Ask Dr. Jones or J. R. Hartley", "Ask Dr. Jones or J. R. Hartley"},
+		{"It cost 3.5 pounds. Cheap", "It cost 3.5 pounds = Cheap"},
+		{"See e.g. this one", "See e.g. this one"},
+		{"Ends. Über alles", "Ends = Über alles"},
+	} {
+		got := Sentences(test.in)
+		if got != test.want {
+			t.Errorf("Sentences(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"plain 'text'", "plain 'text'"},
+		{"“Hello” ‘there’", `"Hello" 'there'`},
+		{"«Bonjour» „Hallo“", `"Bonjour" "Hallo"`},
+		{"it’s `quoted´", "it's 'quoted'"},
+		{"one–two—three − four", "one-two-three - four"},
+		{"wait…", "wait..."},
+	} {
+		got := Quotes(test.in)
+		if got != test.want {
+			t.Errorf("Quotes(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"no urls here", "no urls here"},
+		{"see https://www.example.com/path?q=1#frag now", "see example.com now"},
+		{"HTTP://Example.COM", "Example.COM"},
+		{"ftp://ftp.example.org:21/pub", "ftp.example.org"},
+		{"at http://example.com.", "at example.com."},
+		{"mail mailto:g4abc@example.com today", "mail g4abc@example.com today"},
+		{"mail g4abc@example.com", "mail g4abc@example.com"},
+	} {
+		got := URLs(test.in)
+		if got != test.want {
+			t.Errorf("URLs(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPunctuation(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"Hello, world.", "Hello, world."},
+		{"Note: this", "Note = this"},
+		{"Stop!", "Stop."},
+		{"one; two", "one, two"},
+		{"R&D", "R AND D"},
+		{"50%", "50 PERCENT "},
+		{"#1", " NR 1"},
+		{"a|b", "a/b"},
+		{"[x] {y} <z>", "(x) (y) (z)"},
+	} {
+		got := Punctuation(test.in)
+		if got != test.want {
+			t.Errorf("Punctuation(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestWhitespace(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"one", "one"},
+		{"  one  two  ", "one two"},
+		{"one\ttwo\nthree\r\n", "one two three"},
+		{"one\u00a0two", "one\u00a0two"}, // \s is ASCII only
+	} {
+		got := Whitespace(test.in)
+		if got != test.want {
+			t.Errorf("Whitespace(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		names   []string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{nil, " R&D:  “go” ", " R&D:  “go” ", false},
+		{[]string{"none"}, " R&D ", " R&D ", false},
+		{DefaultSteps, " R&D:  “go” ", `R AND D = "go"`, false},
+		{[]string{"Quotes", " whitespace "}, " “go”  now ", `"go" now`, false},
+		{[]string{"punctuation", "whitespace"}, "a&b", "a AND b", false},
+		{[]string{"whitespace", "punctuation"}, "a&b", "a AND b", false},
+		{[]string{"whitespace", "punctuation"}, "a & b", "a  AND  b", false},
+		{[]string{"sentences"}, "One. Two", "One = Two", false},
+		{[]string{"quotes", ""}, "“go”", `"go"`, false},
+		{[]string{"quotes", "bogus"}, "", "", true},
+	} {
+		n, err := Parse(test.names)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", test.names, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := n.Normalise(test.in)
+		if got != test.want {
+			t.Errorf("Parse(%q).Normalise(%q) = %q, want %q", test.names, test.in, got, test.want)
+		}
+	}
+}
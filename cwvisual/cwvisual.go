@@ -0,0 +1,257 @@
+// Package cwvisual shows Morse code as flashes in the terminal
+package cwvisual
+
+// The Morse is shown on the bottom line of the terminal, which is
+// taken out of the scrolling region so anything else the command
+// prints scrolls above it undisturbed.
+//
+// The keying is timed a dit at a time from the generator at the same
+// rate as the audio, but isn't synchronised with the speaker so it
+// runs ahead of it by the latency of the audio device.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/ncw/cwtool/cw"
+	"github.com/ncw/cwtool/cwgenerator"
+	"golang.org/x/term"
+)
+
+// Scheme is the output file to show the Morse only visually
+const Scheme = "visual:"
+
+// Styles of display
+const (
+	StyleBlock = "block" // the whole line lights up while the key is down
+	StyleBar   = "bar"   // a coloured bar of the keying scrolls along the line
+)
+
+// Styles returns the names of the display styles
+func Styles() []string {
+	return []string{StyleBlock, StyleBar}
+}
+
+var (
+	keyDown = color.New(color.BgGreen).Sprint(" ")
+	letter  = color.New(color.FgYellow, color.Bold).Sprint
+)
+
+// char is a rune added and the dit it ends at
+type char struct {
+	r   rune
+	end int
+}
+
+// Visual shows the Morse on the bottom line of the terminal in real
+// time
+type Visual struct {
+	generator *cwgenerator.Generator
+	clock     *cwgenerator.Clock
+	opt       *cw.Options
+	out       *os.File
+	style     string   // style of display
+	rows      int      // height of the terminal
+	cols      int      // width of the terminal
+	lit       bool     // whether the key is down
+	text      []rune   // letters shown so far for StyleBlock
+	tape      []string // cells of the display for StyleBar
+
+	mu    sync.Mutex
+	chars []char // runes added which haven't been shown yet
+
+	outMu    sync.Mutex
+	restored bool // set when the terminal has been given back
+}
+
+// New makes a Visual showing the Morse on the terminal out in the
+// style opt.VisualStyle
+func New(opt *cw.Options, out *os.File) (*Visual, error) {
+	style := opt.VisualStyle
+	if style == "" {
+		style = StyleBlock
+	}
+	if style != StyleBlock && style != StyleBar {
+		return nil, fmt.Errorf("unknown visual style %q: must be one of %s", style, strings.Join(Styles(), ", "))
+	}
+	cols, rows, err := term.GetSize(int(out.Fd()))
+	if err != nil {
+		return nil, errors.New("showing the Morse visually needs a terminal")
+	}
+	if rows < 2 {
+		return nil, errors.New("terminal too small to show the Morse visually")
+	}
+	v := &Visual{
+		generator: cwgenerator.New(opt),
+		opt:       opt,
+		out:       out,
+		style:     style,
+		rows:      rows,
+		cols:      cols,
+	}
+	// Make sure the cursor isn't on the bottom line then take that
+	// out of the scrolling region. Setting the region homes the
+	// cursor so save and restore it.
+	fmt.Fprintf(out, "\n\033[1A\0337\033[1;%dr\0338", rows-1)
+	v.draw()
+	v.clock = cwgenerator.NewClock(v.generator, cwgenerator.DitDuration(opt.WPM), cwgenerator.Keying{
+		Key:  v.keyDit,
+		Stop: v.stop,
+	})
+	return v, nil
+}
+
+// draw the display on the bottom line
+func (v *Visual) draw() {
+	var line strings.Builder
+	if v.style == StyleBar {
+		tape := v.tape
+		if len(tape) > v.cols {
+			tape = tape[len(tape)-v.cols:]
+		}
+		for _, cell := range tape {
+			line.WriteString(cell)
+		}
+	} else {
+		text := v.text
+		if len(text) > v.cols {
+			text = text[len(text)-v.cols:]
+		}
+		s := fmt.Sprintf("%*s", v.cols, string(text))
+		if v.lit {
+			s = "\033[7m" + s + "\033[0m"
+		}
+		line.WriteString(s)
+	}
+	v.outMu.Lock()
+	defer v.outMu.Unlock()
+	if v.restored {
+		return
+	}
+	fmt.Fprintf(v.out, "\0337\033[%d;1H\033[2K%s\0338", v.rows, line.String())
+}
+
+// key shows the key state for the next dit
+func (v *Visual) key(down bool) {
+	v.lit = down
+	if down {
+		v.tape = append(v.tape, keyDown)
+	} else {
+		v.tape = append(v.tape, " ")
+	}
+}
+
+// show the letters which have finished by dit n
+func (v *Visual) show(n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for len(v.chars) > 0 && v.chars[0].end <= n {
+		r := v.chars[0].r
+		v.chars = v.chars[1:]
+		if v.opt.VisualLetters {
+			v.text = append(v.text, r)
+			v.tape = append(v.tape, letter(string(r)))
+		}
+	}
+	// Don't let these grow without limit
+	if len(v.text) > 2*v.cols {
+		v.text = append([]rune(nil), v.text[len(v.text)-v.cols:]...)
+	}
+	if len(v.tape) > 2*v.cols {
+		v.tape = append([]string(nil), v.tape[len(v.tape)-v.cols:]...)
+	}
+}
+
+// keyDit shows dit n
+func (v *Visual) keyDit(n int, down bool) {
+	v.show(n)
+	v.key(down)
+	v.draw()
+}
+
+// stop shows the end of the Morse after n dits
+func (v *Visual) stop(n int) {
+	v.lit = false
+	v.show(n)
+	v.draw()
+}
+
+// add r to the generator noting where it ends, call with the lock
+// held
+func (v *Visual) _add(r rune) {
+	_, start := v.generator.Position()
+	v.generator.Rune(r)
+	_, end := v.generator.Position()
+	if end == start {
+		// Nothing to show
+		return
+	}
+	if unicode.IsSpace(r) {
+		r = ' '
+	}
+	v.chars = append(v.chars, char{r: unicode.ToUpper(r), end: end})
+}
+
+// Rune adds r to the output
+func (v *Visual) Rune(r rune) {
+	v.mu.Lock()
+	v._add(r)
+	v.mu.Unlock()
+	v.clock.Queue()
+}
+
+// String adds s to the output
+func (v *Visual) String(s string) {
+	v.mu.Lock()
+	for _, r := range s {
+		v._add(r)
+	}
+	v.mu.Unlock()
+	v.clock.Queue()
+}
+
+// Done returns a channel which is closed when all the Morse added so
+// far has been shown.
+//
+// Adding more Morse makes a new channel so Done should be called
+// again afterwards.
+func (v *Visual) Done() <-chan struct{} {
+	return v.clock.Done()
+}
+
+// Sync by waiting for all the Morse to be shown
+func (v *Visual) Sync() error {
+	<-v.Done()
+	return nil
+}
+
+// Restore gives the bottom line of the terminal back straight away,
+// eg if the program is interrupted. Nothing more is shown afterwards.
+func (v *Visual) Restore() error {
+	v.outMu.Lock()
+	defer v.outMu.Unlock()
+	if v.restored {
+		return nil
+	}
+	v.restored = true
+	_, err := fmt.Fprintf(v.out, "\0337\033[r\033[%d;1H\033[2K\0338", v.rows)
+	return err
+}
+
+// Close the display after the Morse has been shown, giving the bottom
+// line of the terminal back
+func (v *Visual) Close() error {
+	v.clock.Close()
+	return v.Restore()
+}
+
+// Check interfaces
+var (
+	_ cw.CW   = (*Visual)(nil)
+	_ cw.Live = (*Visual)(nil)
+)